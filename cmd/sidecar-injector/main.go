@@ -4,14 +4,22 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/cyberark/sidecar-injector/pkg/inject"
 	"github.com/cyberark/sidecar-injector/pkg/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 // Define environment variables used in Secrets Provider config
@@ -30,6 +38,29 @@ func main() {
 	flag.StringVar(&parameters.SecretlessContainerImage, "secretless-image", "cyberark/secretless-broker:latest", "Container image for the Secretless sidecar")
 	flag.StringVar(&parameters.AuthenticatorContainerImage, "authenticator-image", "cyberark/conjur-authn-k8s-client:latest", "Container image for the Kubernetes Authenticator sidecar")
 	flag.StringVar(&parameters.SecretsProviderContainerImage, "secrets-provider-image", "cyberark/secrets-provider-for-k8s:latest", "Container image for the Secrets Provider sidecar")
+	flag.BoolVar(&parameters.SecretsProviderRBAC, "secrets-provider-rbac", false, "Grant the service account of Secrets Provider containers injected in k8s_secrets mode access to the Secrets they list.")
+	flag.BoolVar(&parameters.SecretlessListeners, "secretless-listeners", false, "Read and validate the Secretless configuration of pods at admission, to set their listener environment variables.")
+	flag.BoolVar(&parameters.KeepInjectorAnnotations, "keep-injector-annotations", false, "Keep the conjur.org annotations only used by the sidecar injector on injected pods.")
+	flag.BoolVar(&parameters.UpgradeWorkloads, "upgrade-workloads", false, "Roll out the workloads annotated for injection when the default sidecar images change.")
+	flag.DurationVar(&parameters.UpgradeWorkloadsInterval, "upgrade-workloads-interval", 30*time.Second, "Minimum time between two rollouts of workloads by -upgrade-workloads.")
+	flag.StringVar(&parameters.ConjurConnectConfigMap, "conjur-connect-configmap", "", "Name of the Conjur connect ConfigMap to maintain in labeled namespaces. Disabled when empty.")
+	flag.StringVar(&parameters.GoldenConfigMap, "golden-configmap", "conjur-configmap", "Name of the golden ConfigMap holding the Conjur connection configuration.")
+	flag.StringVar(&parameters.InjectorNamespace, "injector-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the sidecar injector and the golden ConfigMap.")
+	flag.BoolVar(&parameters.NamespaceDefaults, "namespace-defaults", false, "Use conjur.org annotations on a pod's namespace as defaults for the pod's annotations.")
+	flag.BoolVar(&parameters.InjectionPolicies, "injection-policies", false, "Inject pods matched by SidecarInjectionPolicy custom resources.")
+	flag.StringVar(&parameters.ConfigFile, "config", "", "Path to the injector configuration file, e.g. defining injection profiles.")
+	flag.StringVar(&parameters.AdminAddr, "admin-addr", "", "Address of the admin HTTP server serving the /preview endpoint to users allowed to create pods, e.g. 127.0.0.1:8081. Disabled when empty.")
+	flag.StringVar(&parameters.RedactEnv, "redact-env", "*", "Comma-separated patterns of the names of the environment variables whose values are masked in logs and admission records, e.g. CONJUR_*,*PASSWORD*.")
+	flag.BoolVar(&parameters.HashUserInfo, "hash-user-info", false, "Replace the usernames and UIDs of users with their hash in logs, admission records and the audit log.")
+	flag.StringVar(&parameters.RecordFile, "record-file", "", "Path of the JSONL file recording the admission requests and responses. Disabled when empty.")
+	flag.IntVar(&parameters.RecordMaxSize, "record-max-size", 100, "Size in megabytes above which the admission record file is rotated.")
+	flag.IntVar(&parameters.RecordMaxBackups, "record-max-backups", 3, "Number of rotated admission record files kept.")
+	flag.StringVar(&parameters.RecordRedact, "record-redact", "env,userinfo", "Comma-separated redactions of the admission records: env for environment variable values, userinfo for the requesting user.")
+	flag.StringVar(&parameters.AuditLog, "audit-log", "", "Path of the JSONL audit log of the admission decisions, - for stdout. Disabled when empty.")
+	flag.IntVar(&parameters.AuditMaxSize, "audit-max-size", 100, "Size in megabytes above which the audit log is rotated.")
+	flag.IntVar(&parameters.AuditMaxBackups, "audit-max-backups", 10, "Number of rotated audit logs kept.")
+	flag.BoolVar(&parameters.InjectionEvents, "injection-events", false, "Emit Kubernetes Events for the injection outcomes of pods on their controllers, e.g. ReplicaSets and Jobs.")
+	flag.StringVar(&parameters.NamespaceSelectorLabel, "namespace-selector-label", "cyberark-sidecar-injector", "Label set to \"enabled\" on namespaces using the sidecar injector.")
 
 	// Flag.parse only covers `-version` flag but for `version`, we need to explicitly
 	// check the args
@@ -43,6 +74,11 @@ func main() {
 		return
 	}
 
+	// `replay <file>...` replays admission record files offline
+	if flag.Arg(0) == "replay" {
+		os.Exit(replay(parameters, flag.Args()[1:]))
+	}
+
 	log.Printf("cyberark-sidecar-injector v%s starting up...", version.FullVersionName)
 
 	whsvr := &inject.WebhookServer{
@@ -53,9 +89,144 @@ func main() {
 		},
 	}
 
+	if parameters.ConfigFile != "" {
+		configFile, err := inject.LoadConfigFile(parameters.ConfigFile)
+		if err != nil {
+			log.Printf("Failed to load configuration: %v", err)
+			os.Exit(1)
+		}
+		whsvr.ConfigFile = configFile
+	}
+
+	envPatterns, err := inject.ParseEnvPatterns(parameters.RedactEnv)
+	if err != nil {
+		log.Printf("Failed to configure redaction: %v", err)
+		os.Exit(1)
+	}
+	whsvr.Redactor = &inject.Redactor{
+		EnvPatterns:  envPatterns,
+		HashUserInfo: parameters.HashUserInfo,
+	}
+
+	if parameters.RecordFile != "" {
+		redactions, err := inject.ParseRedactions(parameters.RecordRedact)
+		if err != nil {
+			log.Printf("Failed to configure admission records: %v", err)
+			os.Exit(1)
+		}
+		recordFile := &inject.RotatingFile{
+			Path:       parameters.RecordFile,
+			MaxSize:    int64(parameters.RecordMaxSize) * 1024 * 1024,
+			MaxBackups: parameters.RecordMaxBackups,
+		}
+		defer recordFile.Close()
+		whsvr.Recorder = &inject.AdmissionRecorder{
+			Output:   recordFile,
+			Redact:   redactions,
+			Redactor: whsvr.Redactor,
+		}
+		log.Printf("Recording admission requests to %s, redacting %v", parameters.RecordFile, redactions)
+	}
+
+	// the audit log is written to stdout, apart from the logs written to stderr,
+	// or to a file
+	switch parameters.AuditLog {
+	case "":
+	case "-":
+		whsvr.AuditLogger = &inject.AuditLogger{Output: os.Stdout, Redactor: whsvr.Redactor}
+	default:
+		auditFile := &inject.RotatingFile{
+			Path:       parameters.AuditLog,
+			MaxSize:    int64(parameters.AuditMaxSize) * 1024 * 1024,
+			MaxBackups: parameters.AuditMaxBackups,
+		}
+		defer auditFile.Close()
+		whsvr.AuditLogger = &inject.AuditLogger{Output: auditFile, Redactor: whsvr.Redactor}
+	}
+
+	if parameters.SecretsProviderRBAC ||
+		parameters.SecretlessListeners ||
+		parameters.UpgradeWorkloads ||
+		parameters.ConjurConnectConfigMap != "" ||
+		parameters.NamespaceDefaults ||
+		parameters.InjectionPolicies ||
+		parameters.InjectionEvents ||
+		parameters.AdminAddr != "" {
+		kubeClient, dynamicClient, err := newKubeClients()
+		if err != nil {
+			log.Printf("Failed to create Kubernetes client: %v", err)
+			os.Exit(1)
+		}
+		whsvr.KubeClient = kubeClient
+		whsvr.DynamicClient = dynamicClient
+	}
+
+	if parameters.InjectionEvents {
+		eventRecorder, stopEvents := inject.NewInjectionEventRecorder(whsvr.KubeClient)
+		defer stopEvents()
+		whsvr.EventRecorder = eventRecorder
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if parameters.NamespaceDefaults {
+		factory := informers.NewSharedInformerFactory(whsvr.KubeClient, 0)
+		whsvr.NamespaceLister = factory.Core().V1().Namespaces().Lister()
+		factory.Start(ctx.Done())
+		for _, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				log.Printf("Failed to sync namespace cache")
+				os.Exit(1)
+			}
+		}
+	}
+
+	if parameters.InjectionPolicies {
+		factory := dynamicinformer.NewDynamicSharedInformerFactory(whsvr.DynamicClient, 0)
+		whsvr.PolicyLister = inject.NewSidecarInjectionPolicyLister(
+			factory.ForResource(inject.SidecarInjectionPolicyResource).Lister(),
+		)
+		factory.Start(ctx.Done())
+		for _, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				log.Printf("Failed to sync SidecarInjectionPolicy cache")
+				os.Exit(1)
+			}
+		}
+		whsvr.PolicyStatus = inject.NewPolicyStatusUpdater(whsvr.DynamicClient)
+		go whsvr.PolicyStatus.Run(ctx)
+	}
+
+	// The replicas of the injector elect the one reconciling the Conjur connect
+	// ConfigMaps and the one upgrading the workloads, identified by its pod name
+	identity, err := os.Hostname()
+	electionPossible := err == nil && parameters.InjectorNamespace != ""
+
+	if parameters.ConjurConnectConfigMap != "" {
+		if !electionPossible {
+			log.Printf("Failed to elect Conjur connect ConfigMap reconciler: -injector-namespace and the hostname are required")
+			os.Exit(1)
+		}
+		reconciler := &inject.ConjurConnectReconciler{
+			Client:               whsvr.KubeClient,
+			GoldenNamespace:      parameters.InjectorNamespace,
+			GoldenConfigMapName:  parameters.GoldenConfigMap,
+			ConnectConfigMapName: parameters.ConjurConnectConfigMap,
+			NamespaceLabel:       parameters.NamespaceSelectorLabel,
+		}
+		go func() {
+			if err := reconciler.RunAsLeader(ctx, parameters.InjectorNamespace, identity); err != nil {
+				log.Printf("Conjur connect ConfigMap reconciler failed: %v", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// define http server and server handler
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mutate", whsvr.Serve)
+	mux.Handle("/metrics", promhttp.Handler())
 	whsvr.Server.Handler = mux
 
 	// start webhook server in goroutine
@@ -82,11 +253,121 @@ func main() {
 		}
 	}()
 
+	// start the admin server, which isn't exposed by the webhook service
+	var adminServer *http.Server
+	if parameters.AdminAddr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.HandleFunc("/preview", whsvr.Preview)
+		adminServer = &http.Server{
+			Addr:    parameters.AdminAddr,
+			Handler: adminMux,
+		}
+		go func() {
+			log.Printf("Serving injection previews on %s", adminServer.Addr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Failed to listen and serve admin server: %v", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if parameters.UpgradeWorkloads {
+		upgrader := &inject.WorkloadUpgrader{
+			Client:          whsvr.KubeClient,
+			Config:          whsvr.InjectorConfig(),
+			NamespaceLabel:  parameters.NamespaceSelectorLabel,
+			RolloutInterval: parameters.UpgradeWorkloadsInterval,
+		}
+		if !electionPossible {
+			log.Printf("Failed to elect workload upgrader: -injector-namespace and the hostname are required")
+			os.Exit(1)
+		}
+		go func() {
+			if err := upgrader.RunAsLeader(ctx, parameters.InjectorNamespace, identity); err != nil {
+				log.Printf("Failed to upgrade workloads: %v", err)
+			}
+		}()
+	}
+
 	// listen for OS shutdown signal
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 	<-signalChan
 
 	log.Printf("Received OS shutdown signal, shutting down webhook server gracefully...")
+	cancel()
 	whsvr.Server.Shutdown(context.Background())
+	if adminServer != nil {
+		adminServer.Shutdown(context.Background())
+	}
+}
+
+// replay replays admission record files through the sidecar injector logic,
+// configured by the flags but without access to the cluster, and returns the
+// exit code: 1 when a response differs from the recorded one
+func replay(parameters inject.WebhookServerParameters, files []string) int {
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: cyberark-sidecar-injector [flags] replay <admission record file>...")
+		return 2
+	}
+
+	// Only the summary and the differences are written to stdout
+	log.SetOutput(io.Discard)
+
+	whsvr := &inject.WebhookServer{Params: parameters}
+	if parameters.ConfigFile != "" {
+		configFile, err := inject.LoadConfigFile(parameters.ConfigFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			return 1
+		}
+		whsvr.ConfigFile = configFile
+	}
+
+	exitCode := 0
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open admission records: %v\n", err)
+			return 1
+		}
+		summary, err := inject.ReplayAdmissionRecords(whsvr.InjectorConfig(), file, os.Stdout)
+		file.Close()
+		fmt.Printf(
+			"%s: %d matched, %d mismatched, %d skipped\n",
+			path,
+			summary.Matched,
+			summary.Mismatched,
+			summary.Skipped,
+		)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to replay %s: %v\n", path, err)
+			return 1
+		}
+		if summary.Mismatched > 0 {
+			exitCode = 1
+		}
+	}
+
+	return exitCode
+}
+
+// newKubeClients creates Kubernetes clients, for built-in and for custom
+// resources, from the in-cluster configuration of the pod the injector runs in
+func newKubeClients() (kubernetes.Interface, dynamic.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return kubeClient, dynamicClient, nil
 }
@@ -23,6 +23,10 @@ The following flags are available to configure the sidecar-injector deployment.
 
        --secrets-provider          Option to use secrets provider and use the conjur-connect config map
 
+       --secrets-provider-rbac     Grant the service accounts of Secrets Provider containers
+                                   injected in k8s_secrets mode access to the Secrets their
+                                   pods list.
+
 EOF
     exit 1
 }
@@ -65,6 +69,9 @@ while [[ $# -gt 0 ]]; do
             secretsProviderImageArg="- -secrets-provider-image=${2}"
             shift
             ;;
+        --secrets-provider-rbac)
+            secretsProviderRBACArg="- -secrets-provider-rbac"
+            ;;
         --secrets-provider)
             secretsProvider="envFrom:
           - configMapRef:
@@ -105,6 +112,7 @@ spec:
             ${authenticatorImageArg}
             ${secretlessImageArg}
             ${secretsProviderImageArg}
+            ${secretsProviderRBACArg}
           ports:
             - containerPort: 8080
               name: https
@@ -0,0 +1,153 @@
+package inject
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Outcomes of the admission decisions in the audit log
+const (
+	AuditOutcomeInjected = "injected" // Sidecars injected into a pod
+	AuditOutcomeMutated  = "mutated"  // Object changed without injection, e.g. ephemeral containers
+	AuditOutcomeSkipped  = "skipped"  // Object admitted unchanged
+	AuditOutcomeDenied   = "denied"   // Object rejected because of an error
+)
+
+// AuditRecord is a line of the audit log: an admission decision of the
+// sidecar injector, who asked for it and which sidecars it injected
+type AuditRecord struct {
+	Time      time.Time                 `json:"time"`
+	UID       types.UID                 `json:"uid"`
+	UserInfo  authenticationv1.UserInfo `json:"userInfo"`
+	Operation admissionv1.Operation     `json:"operation"`
+	Kind      string                    `json:"kind"`
+	Namespace string                    `json:"namespace"`
+	Name      string                    `json:"name"`
+	DryRun    bool                      `json:"dryRun,omitempty"`
+	// Controller of the object, e.g. the ReplicaSet of a pod
+	Owner *metav1.OwnerReference `json:"owner,omitempty"`
+	// Inject types and images of the injected sidecars
+	InjectTypes []string     `json:"injectTypes,omitempty"`
+	Images      []AuditImage `json:"images,omitempty"`
+	Outcome     string       `json:"outcome"`
+	Reason      string       `json:"reason,omitempty"`
+}
+
+// AuditImage is the image of a container injected for an inject type
+type AuditImage struct {
+	InjectType string `json:"injectType"`
+	Image      string `json:"image"`
+}
+
+// AuditLogger writes an audit record as a JSON line for every admission
+// decision, to a RotatingFile or stdout, separately from the debug logs
+type AuditLogger struct {
+	Output   io.Writer // Destination of the audit records
+	Redactor *Redactor // Hashing of the users, none when nil
+
+	mutex sync.Mutex
+}
+
+// Log writes the audit record of an admission request and its response
+func (logger *AuditLogger) Log(
+	req *admissionv1.AdmissionRequest,
+	res *admissionv1.AdmissionResponse,
+) error {
+	record := newAuditRecord(req, res)
+	if logger.Redactor != nil {
+		record.UserInfo = logger.Redactor.userInfo(record.UserInfo)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	_, err = logger.Output.Write(append(line, '\n'))
+	return err
+}
+
+// newAuditRecord returns the audit record of an admission request and its
+// response. The inject types and images are read from the provenance
+// annotations of the patched object.
+func newAuditRecord(
+	req *admissionv1.AdmissionRequest,
+	res *admissionv1.AdmissionResponse,
+) *AuditRecord {
+	record := &AuditRecord{
+		Time:      now().UTC(),
+		UID:       req.UID,
+		UserInfo:  req.UserInfo,
+		Operation: req.Operation,
+		Kind:      req.Kind.Kind,
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		DryRun:    newSideEffects(req).dryRun,
+	}
+
+	var object metav1.PartialObjectMetadata
+	if err := json.Unmarshal(req.Object.Raw, &object); err == nil {
+		if record.Name == "" {
+			record.Name = metaName(&object.ObjectMeta)
+		}
+		record.Owner = metav1.GetControllerOf(&object)
+	}
+
+	switch {
+	case res.Result != nil:
+		record.Outcome = AuditOutcomeDenied
+		record.Reason = res.Result.Message
+	case len(res.Patch) == 0:
+		record.Outcome = AuditOutcomeSkipped
+	default:
+		record.Outcome = AuditOutcomeMutated
+		annotations, err := patchedAnnotations(req.Object.Raw, res.Patch)
+		if err != nil {
+			record.Reason = "could not read the patched object: " + err.Error()
+			break
+		}
+		// Pods keep the provenance annotations after their creation
+		injectTypes := annotations[annotationInjectedTypesKey]
+		if req.Operation != admissionv1.Create || injectTypes == "" {
+			break
+		}
+		record.Outcome = AuditOutcomeInjected
+		record.InjectTypes = strings.Split(injectTypes, ",")
+		for _, image := range strings.Split(annotations[annotationInjectedImagesKey], ",") {
+			if injectType, image, found := strings.Cut(image, "="); found {
+				record.Images = append(record.Images, AuditImage{InjectType: injectType, Image: image})
+			}
+		}
+	}
+
+	return record
+}
+
+// patchedAnnotations returns the annotations of an object in JSON after
+// applying a patch
+func patchedAnnotations(object, patch []byte) (map[string]string, error) {
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := decoded.Apply(object)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata metav1.PartialObjectMetadata
+	if err := json.Unmarshal(patched, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata.Annotations, nil
+}
@@ -0,0 +1,136 @@
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestOwnedAdmissionRequest returns the Admission Request of a pod fixture
+// owned by a ReplicaSet
+func newTestOwnedAdmissionRequest(podPath string, owner metav1.OwnerReference) (*admissionv1.AdmissionRequest, error) {
+	reqJSON, err := newTestAdmissionRequest(podPath)
+	if err != nil {
+		return nil, err
+	}
+	req, err := NewAdmissionRequest(reqJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return nil, err
+	}
+	pod.OwnerReferences = []metav1.OwnerReference{owner}
+	req.Object.Raw, err = json.Marshal(pod)
+	return req, err
+}
+
+func TestAuditLogger(t *testing.T) {
+	controller := true
+	owner := metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "nginx-deployment-6c54bd5869",
+		UID:        "c5b4bd8b-5f29-11e8-8a3c-36e6bb280816",
+		Controller: &controller,
+	}
+	userInfo := "system:serviceaccount:kube-system:replicaset-controller"
+
+	var testCases = []struct {
+		description string
+		podPath     string
+		annotations map[string]string
+		operation   admissionv1.Operation
+		expected    AuditRecord
+	}{
+		{
+			description: "injected pod",
+			podPath:     "./testdata/multi-type-annotated-pod.json",
+			expected: AuditRecord{
+				Outcome:     AuditOutcomeInjected,
+				InjectTypes: []string{"secretless", "secrets-provider"},
+				Images: []AuditImage{
+					{InjectType: "secretless", Image: "secretless-image"},
+					{InjectType: "secrets-provider", Image: "custom-secrets-provider-image"},
+				},
+			},
+		},
+		{
+			description: "pod not annotated for injection",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			annotations: map[string]string{"conjur.org/inject": "false"},
+			expected:    AuditRecord{Outcome: AuditOutcomeSkipped},
+		},
+		{
+			description: "failed injection",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			annotations: map[string]string{"conjur.org/inject-type": "unknown"},
+			expected: AuditRecord{
+				Outcome: AuditOutcomeDenied,
+				Reason:  "Mutation failed for pod , in namespace dummy, due to invalid inject type annotation value = unknown",
+			},
+		},
+		{
+			description: "pod update",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			operation:   admissionv1.Update,
+			expected:    AuditRecord{Outcome: AuditOutcomeSkipped},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req, err := newTestOwnedAdmissionRequest(tc.podPath, owner)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if tc.annotations != nil {
+				var pod corev1.Pod
+				if !assert.NoError(t, json.Unmarshal(req.Object.Raw, &pod)) {
+					return
+				}
+				for key, value := range tc.annotations {
+					pod.Annotations[key] = value
+				}
+				if req.Object.Raw, err = json.Marshal(pod); !assert.NoError(t, err) {
+					return
+				}
+			}
+			if tc.operation != "" {
+				req.Operation = tc.operation
+			}
+			res := HandleAdmissionRequest(newTestSidecarInjectorConfig(), req)
+
+			var output bytes.Buffer
+			logger := &AuditLogger{Output: &output}
+			if !assert.NoError(t, logger.Log(req, &res)) {
+				return
+			}
+
+			var record AuditRecord
+			if !assert.NoError(t, json.Unmarshal(output.Bytes(), &record)) {
+				return
+			}
+			expected := tc.expected
+			expected.Time = testInjectionTime
+			expected.UID = "0df28fbd-5f5f-11e8-bc74-36e6bb280816"
+			expected.Operation = req.Operation
+			expected.Kind = "Pod"
+			expected.Namespace = "dummy"
+			expected.Name = "nginx-deployment-6c54bd5869-"
+			expected.Owner = &owner
+			assert.Equal(t, userInfo, record.UserInfo.Username)
+			assert.NotEmpty(t, record.UserInfo.Groups)
+			record.UserInfo = authenticationv1.UserInfo{}
+			assert.Equal(t, expected, record)
+		})
+	}
+}
@@ -38,13 +38,7 @@ func mutationRequired(ignoredList []string, metadata *metav1.ObjectMeta) bool {
 	// determine whether to perform mutation based on annotation for the target resource
 	required := strings.ToLower(injectedStatus) != "injected"
 	if required {
-		injectValue, _ := getAnnotation(metadata, annotationInjectKey)
-		switch strings.ToLower(injectValue) {
-		case "y", "yes", "true", "on":
-			required = true
-		default:
-			required = false
-		}
+		required = annotationEnabled(metadata, annotationInjectKey)
 	}
 
 	log.Printf(
@@ -104,6 +98,33 @@ func getAnnotation(metadata *metav1.ObjectMeta, key string) (string, error) {
 	return value, nil
 }
 
+// annotationEnabled tells whether a boolean annotation is set to a truthy
+// value: y, yes, true or on, in any case
+func annotationEnabled(metadata *metav1.ObjectMeta, key string) bool {
+	value, _ := getAnnotation(metadata, key)
+	switch strings.ToLower(value) {
+	case "y", "yes", "true", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// annotationDisabled tells whether a boolean annotation is set to a falsy
+// value: n, no, false or off, in any case
+func annotationDisabled(metadata *metav1.ObjectMeta, key string) bool {
+	value, _ := getAnnotation(metadata, key)
+	switch strings.ToLower(value) {
+	case "n", "no", "false", "off":
+		return true
+	default:
+		return false
+	}
+}
+
+// Path at which the service account token is mounted in containers
+const serviceAccountTokenMountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
 /**
  * Given a pod, return the name of the volume that contains
  * the service account token. VolumeMounts are used instead
@@ -114,7 +135,7 @@ func getAnnotation(metadata *metav1.ObjectMeta, key string) (string, error) {
 func getServiceAccountTokenVolumeName(pod *corev1.Pod) (string, error) {
 	for _, container := range pod.Spec.Containers {
 		for _, volumeMount := range container.VolumeMounts {
-			if volumeMount.MountPath == "/var/run/secrets/kubernetes.io/serviceaccount" {
+			if volumeMount.MountPath == serviceAccountTokenMountPath {
 				return volumeMount.Name, nil
 			}
 		}
@@ -7,8 +7,18 @@ import (
 type ContainerVolumeMounts map[string][]corev1.VolumeMount
 
 type PatchConfig struct {
-	InitContainers        []corev1.Container    `yaml:"initContainers"`
-	Containers            []corev1.Container    `yaml:"containers"`
-	Volumes               []corev1.Volume       `yaml:"volumes"`
-	ContainerVolumeMounts ContainerVolumeMounts `yaml:"volumeMounts"`
+	InitContainers        []corev1.Container    `yaml:"initContainers" json:"initContainers,omitempty"`
+	Containers            []corev1.Container    `yaml:"containers" json:"containers,omitempty"`
+	Volumes               []corev1.Volume       `yaml:"volumes" json:"volumes,omitempty"`
+	ContainerVolumeMounts ContainerVolumeMounts `yaml:"volumeMounts" json:"volumeMounts,omitempty"`
+	// Liveness probes set on containers of the pod, by container name
+	ContainerLivenessProbes map[string]*corev1.Probe `yaml:"livenessProbes" json:"livenessProbes,omitempty"`
+	// Environment variables added to containers of the pod, by container name
+	ContainerEnv map[string][]corev1.EnvVar `yaml:"env" json:"env,omitempty"`
+	// Names of the added containers that start before the other containers
+	StartFirst []string `yaml:"startFirst" json:"startFirst,omitempty"`
+	// Warnings returned to the client that created the pod
+	Warnings []string `yaml:"-" json:"-"`
+	// RBAC of Secrets Provider, written once the patch is validated
+	SecretsProviderRBAC []SecretsProviderRBACConfig `yaml:"-" json:"-"`
 }
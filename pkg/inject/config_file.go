@@ -0,0 +1,102 @@
+package inject
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigFile is the content of the optional injector configuration file,
+// holding settings too structured to be passed as command line flags
+type ConfigFile struct {
+	// Named injection profiles, referenced by the `conjur.org/injection-profile`
+	// annotation
+	Profiles map[string]InjectionProfile `json:"profiles,omitempty"`
+	// Operator-defined inject types, selected by the `conjur.org/inject-type`
+	// annotation like the built-in ones
+	Templates map[string]SidecarTemplate `json:"templates,omitempty"`
+	// Environment variables of the injected containers
+	Env EnvConfig `json:"env,omitempty"`
+	// Egress proxy and CA certificates of the cluster, propagated to the
+	// injected containers
+	Proxy           ProxyConfig            `json:"proxy,omitempty"`
+	TrustedCABundle *TrustedCABundleConfig `json:"trustedCABundle,omitempty"`
+	// Timing of the liveness probes added for `conjur.org/restart-app-via-liveness`
+	RestartAppViaLiveness LivenessConfig `json:"restartAppViaLiveness,omitempty"`
+	// Probes of the injected containers, by inject type, replacing the
+	// built-in defaults
+	Probes map[string]ContainerProbes `json:"probes,omitempty"`
+}
+
+// LoadConfigFile reads and validates the injector configuration file at the
+// given path. Unknown fields are rejected to catch typos early.
+func LoadConfigFile(path string) (ConfigFile, error) {
+	var config ConfigFile
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("unable to read config file %s: %v", path, err)
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if err := config.parseTemplates(); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// parseTemplates parses the sidecar templates once, rather than at every
+// admission
+func (config ConfigFile) parseTemplates() error {
+	for name, template := range config.Templates {
+		parsed, err := template.parse(name)
+		if err != nil {
+			return fmt.Errorf("template %q: %v", name, err)
+		}
+		template.parsed = parsed
+		config.Templates[name] = template
+	}
+
+	return nil
+}
+
+func (config ConfigFile) validate() error {
+	for name, profile := range config.Profiles {
+		if name == "" {
+			return fmt.Errorf("profile names must not be empty")
+		}
+		if err := profile.validate(); err != nil {
+			return fmt.Errorf("profile %q: %v", name, err)
+		}
+	}
+	if err := config.Env.validate(); err != nil {
+		return err
+	}
+	if err := config.TrustedCABundle.validate(); err != nil {
+		return err
+	}
+	if err := config.RestartAppViaLiveness.validate(); err != nil {
+		return err
+	}
+	for name, template := range config.Templates {
+		if err := template.validate(name); err != nil {
+			return fmt.Errorf("template %q: %v", name, err)
+		}
+	}
+	for injectType, probes := range config.Probes {
+		if _, ok := lookupInjector(config, injectType); !ok {
+			return fmt.Errorf("probes set for unknown inject type %q", injectType)
+		}
+		if err := probes.validate(); err != nil {
+			return fmt.Errorf("probes of %q: %v", injectType, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package inject
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// conjurConnectResync is how often every labeled namespace is reconciled even
+// when no change has been observed
+const conjurConnectResync = 10 * time.Minute
+
+// conjurConnectLease is the Lease held by the injector replica elected to
+// reconcile the Conjur connect ConfigMaps
+const conjurConnectLease = "cyberark-sidecar-injector-conjur-connect"
+
+var (
+	conjurConnectDrift = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cyberark_sidecar_injector_conjur_connect_drift_total",
+			Help: "Number of Conjur connect ConfigMaps found out of sync with the golden ConfigMap and corrected.",
+		},
+	)
+	conjurConnectReconcileErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cyberark_sidecar_injector_conjur_connect_reconcile_errors_total",
+			Help: "Number of failed Conjur connect ConfigMap reconciliations.",
+		},
+	)
+	conjurConnectManaged = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cyberark_sidecar_injector_conjur_connect_managed_namespaces",
+			Help: "Number of namespaces holding a Conjur connect ConfigMap managed by the injector.",
+		},
+	)
+)
+
+// ConjurConnectReconciler replicates the golden Conjur ConfigMap into a Conjur
+// connect ConfigMap in every namespace labeled for sidecar injection, and
+// removes it again once the label is removed.
+type ConjurConnectReconciler struct {
+	Client               kubernetes.Interface
+	GoldenNamespace      string // Namespace of the golden ConfigMap
+	GoldenConfigMapName  string // Name of the golden ConfigMap
+	ConnectConfigMapName string // Name of the ConfigMap created in application namespaces
+	NamespaceLabel       string // Label set to "enabled" on namespaces using the injector
+
+	queue   workqueue.TypedRateLimitingInterface[string]
+	managed map[string]bool
+}
+
+// RunAsLeader reconciles while elected leader among the replicas of the
+// injector by holding a Lease in the given namespace, so that replicas don't
+// race to create, update and delete the same ConfigMaps. Another replica takes
+// over when the leader stops.
+func (r *ConjurConnectReconciler) RunAsLeader(ctx context.Context, namespace, identity string) error {
+	return runAsLeader(ctx, r.Client, namespace, conjurConnectLease, identity, r.Run)
+}
+
+// Run watches namespaces, the golden ConfigMap and the managed ConfigMaps and
+// reconciles until the context is cancelled.
+func (r *ConjurConnectReconciler) Run(ctx context.Context) error {
+	defer utilruntime.HandleCrash()
+
+	r.queue = workqueue.NewTypedRateLimitingQueue(
+		workqueue.DefaultTypedControllerRateLimiter[string](),
+	)
+	r.managed = map[string]bool{}
+	defer r.queue.ShutDown()
+
+	namespaceFactory := informers.NewSharedInformerFactoryWithOptions(
+		r.Client,
+		conjurConnectResync,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.Set{r.NamespaceLabel: "enabled"}.String()
+		}),
+	)
+	namespaceInformer := namespaceFactory.Core().V1().Namespaces().Informer()
+	namespaceLister := namespaceFactory.Core().V1().Namespaces().Lister()
+	_, err := namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.enqueueNamespace,
+		UpdateFunc: func(_, obj interface{}) { r.enqueueNamespace(obj) },
+		DeleteFunc: r.enqueueNamespace,
+	})
+	if err != nil {
+		return err
+	}
+
+	goldenFactory := informers.NewSharedInformerFactoryWithOptions(
+		r.Client,
+		conjurConnectResync,
+		informers.WithNamespace(r.GoldenNamespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector(
+				"metadata.name",
+				r.GoldenConfigMapName,
+			).String()
+		}),
+	)
+	enqueueAll := func() {
+		namespaces, err := namespaceLister.List(labels.Everything())
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		for _, namespace := range namespaces {
+			r.queue.Add(namespace.Name)
+		}
+	}
+	_, err = goldenFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { enqueueAll() },
+			UpdateFunc: func(_, _ interface{}) { enqueueAll() },
+			DeleteFunc: func(interface{}) { enqueueAll() },
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	managedFactory := informers.NewSharedInformerFactoryWithOptions(
+		r.Client,
+		conjurConnectResync,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.Set{managedByLabelKey: managedByLabelValue}.String()
+			options.FieldSelector = fields.OneTermEqualSelector(
+				"metadata.name",
+				r.ConnectConfigMapName,
+			).String()
+		}),
+	)
+	// Managed ConfigMaps listed at startup are reconciled too, which deletes
+	// those left behind in namespaces unlabeled while no replica was leading
+	_, err = managedFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    r.enqueueNamespace,
+			UpdateFunc: func(_, obj interface{}) { r.enqueueNamespace(obj) },
+			DeleteFunc: r.enqueueNamespace,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	namespaceFactory.Start(ctx.Done())
+	goldenFactory.Start(ctx.Done())
+	managedFactory.Start(ctx.Done())
+	for _, synced := range []map[reflect.Type]bool{
+		namespaceFactory.WaitForCacheSync(ctx.Done()),
+		goldenFactory.WaitForCacheSync(ctx.Done()),
+		managedFactory.WaitForCacheSync(ctx.Done()),
+	} {
+		for informerType, ok := range synced {
+			if !ok {
+				return fmt.Errorf("failed to sync informer cache for %v", informerType)
+			}
+		}
+	}
+
+	log.Printf(
+		"Reconciling ConfigMap %s in namespaces labeled %s=enabled from %s/%s",
+		r.ConnectConfigMapName,
+		r.NamespaceLabel,
+		r.GoldenNamespace,
+		r.GoldenConfigMapName,
+	)
+	go func() {
+		for r.processNextItem(ctx) {
+		}
+	}()
+
+	<-ctx.Done()
+	return nil
+}
+
+func (r *ConjurConnectReconciler) enqueueNamespace(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	switch o := obj.(type) {
+	case *corev1.Namespace:
+		r.queue.Add(o.Name)
+	case *corev1.ConfigMap:
+		r.queue.Add(o.Namespace)
+	}
+}
+
+func (r *ConjurConnectReconciler) processNextItem(ctx context.Context) bool {
+	namespace, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(namespace)
+
+	if err := r.reconcile(ctx, namespace); err != nil {
+		conjurConnectReconcileErrors.Inc()
+		log.Printf("Failed to reconcile ConfigMap %s/%s: %v", namespace, r.ConnectConfigMapName, err)
+		r.queue.AddRateLimited(namespace)
+		return true
+	}
+	r.queue.Forget(namespace)
+
+	return true
+}
+
+// reconcile brings the Conjur connect ConfigMap of a namespace in line with
+// the golden ConfigMap, or deletes it if the namespace is no longer labeled
+func (r *ConjurConnectReconciler) reconcile(ctx context.Context, namespace string) error {
+	ns, err := r.Client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) || (err == nil && ns.Labels[r.NamespaceLabel] != "enabled") {
+		return r.deleteConnectConfigMap(ctx, namespace)
+	}
+	if err != nil {
+		return err
+	}
+
+	golden, err := r.Client.CoreV1().ConfigMaps(r.GoldenNamespace).Get(
+		ctx,
+		r.GoldenConfigMapName,
+		metav1.GetOptions{},
+	)
+	if err != nil {
+		return fmt.Errorf(
+			"unable to read golden ConfigMap %s/%s: %v",
+			r.GoldenNamespace,
+			r.GoldenConfigMapName,
+			err,
+		)
+	}
+	data := conjurConnectData(golden.Data)
+
+	configMaps := r.Client.CoreV1().ConfigMaps(namespace)
+	existing, err := configMaps.Get(ctx, r.ConnectConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		log.Printf("Creating ConfigMap %s/%s", namespace, r.ConnectConfigMapName)
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      r.ConnectConfigMapName,
+				Namespace: namespace,
+				Labels: map[string]string{
+					managedByLabelKey: managedByLabelValue,
+				},
+			},
+			Data: data,
+		}, metav1.CreateOptions{})
+		if err == nil {
+			r.setManaged(namespace, true)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing.Labels[managedByLabelKey] != managedByLabelValue {
+		log.Printf(
+			"Skipping ConfigMap %s/%s which is not managed by the sidecar injector",
+			namespace,
+			r.ConnectConfigMapName,
+		)
+		return nil
+	}
+	r.setManaged(namespace, true)
+	if reflect.DeepEqual(existing.Data, data) {
+		return nil
+	}
+
+	log.Printf("Correcting drift of ConfigMap %s/%s", namespace, r.ConnectConfigMapName)
+	conjurConnectDrift.Inc()
+	existing.Data = data
+	_, err = configMaps.Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
+
+func (r *ConjurConnectReconciler) deleteConnectConfigMap(ctx context.Context, namespace string) error {
+	configMaps := r.Client.CoreV1().ConfigMaps(namespace)
+	existing, err := configMaps.Get(ctx, r.ConnectConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		r.setManaged(namespace, false)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Labels[managedByLabelKey] != managedByLabelValue {
+		return nil
+	}
+
+	log.Printf("Deleting ConfigMap %s/%s", namespace, r.ConnectConfigMapName)
+	err = configMaps.Delete(ctx, r.ConnectConfigMapName, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	r.setManaged(namespace, false)
+
+	return nil
+}
+
+func (r *ConjurConnectReconciler) setManaged(namespace string, managed bool) {
+	if r.managed == nil {
+		r.managed = map[string]bool{}
+	}
+	if managed {
+		r.managed[namespace] = true
+	} else {
+		delete(r.managed, namespace)
+	}
+	conjurConnectManaged.Set(float64(len(r.managed)))
+}
+
+// conjurConnectData derives the content of a Conjur connect ConfigMap from the
+// golden ConfigMap, accepting either naming convention for its keys
+func conjurConnectData(golden map[string]string) map[string]string {
+	certificate := getConjurEnv(golden, "CONJUR_SSL_CERTIFICATE", "conjurSslCertificate")
+
+	return map[string]string{
+		"CONJUR_ACCOUNT":          getConjurEnv(golden, "CONJUR_ACCOUNT", "conjurAccount"),
+		"CONJUR_APPLIANCE_URL":    getConjurEnv(golden, "CONJUR_APPLIANCE_URL", "conjurApplianceUrl"),
+		"CONJUR_AUTHENTICATOR_ID": getConjurEnv(golden, "CONJUR_AUTHENTICATOR_ID", "authnK8sAuthenticatorID"),
+		"CONJUR_AUTHN_URL":        getConjurAuthnURL(golden),
+		"CONJUR_SSL_CERTIFICATE":  certificate,
+		"ca.crt":                  certificate,
+	}
+}
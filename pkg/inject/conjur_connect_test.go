@@ -0,0 +1,177 @@
+package inject
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestConjurConnectReconciler(objects ...interface{}) *ConjurConnectReconciler {
+	golden := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "conjur-configmap", Namespace: "injectors"},
+		Data: map[string]string{
+			"conjurAccount":           "myConjurAccount",
+			"conjurApplianceUrl":      "https://conjur-oss.conjur-oss.svc.cluster.local",
+			"authnK8sAuthenticatorID": "my-authenticator-id",
+			"conjurSslCertificate":    "-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n",
+		},
+	}
+	client := fake.NewClientset(golden)
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *corev1.Namespace:
+			_, _ = client.CoreV1().Namespaces().Create(context.Background(), o, metav1.CreateOptions{})
+		case *corev1.ConfigMap:
+			_, _ = client.CoreV1().ConfigMaps(o.Namespace).Create(context.Background(), o, metav1.CreateOptions{})
+		}
+	}
+
+	return &ConjurConnectReconciler{
+		Client:               client,
+		GoldenNamespace:      "injectors",
+		GoldenConfigMapName:  "conjur-configmap",
+		ConnectConfigMapName: "conjur-connect",
+		NamespaceLabel:       "cyberark-sidecar-injector",
+	}
+}
+
+func labeledNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"cyberark-sidecar-injector": "enabled"},
+		},
+	}
+}
+
+func TestConjurConnectReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("creates ConfigMap in labeled namespace", func(t *testing.T) {
+		r := newTestConjurConnectReconciler(labeledNamespace("app"))
+
+		assert.NoError(t, r.reconcile(ctx, "app"))
+
+		cm, err := r.Client.CoreV1().ConfigMaps("app").Get(ctx, "conjur-connect", metav1.GetOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, managedByLabelValue, cm.Labels[managedByLabelKey])
+		assert.Equal(t, "myConjurAccount", cm.Data["CONJUR_ACCOUNT"])
+		assert.Equal(
+			t,
+			"https://conjur-oss.conjur-oss.svc.cluster.local/authn-k8s/my-authenticator-id",
+			cm.Data["CONJUR_AUTHN_URL"],
+		)
+		assert.Equal(t, cm.Data["CONJUR_SSL_CERTIFICATE"], cm.Data["ca.crt"])
+	})
+
+	t.Run("corrects drift", func(t *testing.T) {
+		r := newTestConjurConnectReconciler(
+			labeledNamespace("drifted"),
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "conjur-connect",
+					Namespace: "drifted",
+					Labels:    map[string]string{managedByLabelKey: managedByLabelValue},
+				},
+				Data: map[string]string{"CONJUR_ACCOUNT": "tampered"},
+			},
+		)
+
+		drift := testutil.ToFloat64(conjurConnectDrift)
+		assert.NoError(t, r.reconcile(ctx, "drifted"))
+
+		cm, err := r.Client.CoreV1().ConfigMaps("drifted").Get(ctx, "conjur-connect", metav1.GetOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "myConjurAccount", cm.Data["CONJUR_ACCOUNT"])
+		assert.Equal(t, drift+1, testutil.ToFloat64(conjurConnectDrift))
+	})
+
+	t.Run("leaves unmanaged ConfigMap alone", func(t *testing.T) {
+		r := newTestConjurConnectReconciler(
+			labeledNamespace("prepped"),
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "conjur-connect", Namespace: "prepped"},
+				Data:       map[string]string{"CONJUR_ACCOUNT": "prepped"},
+			},
+		)
+
+		assert.NoError(t, r.reconcile(ctx, "prepped"))
+
+		cm, err := r.Client.CoreV1().ConfigMaps("prepped").Get(ctx, "conjur-connect", metav1.GetOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "prepped", cm.Data["CONJUR_ACCOUNT"])
+	})
+
+	t.Run("deletes ConfigMap when label is removed", func(t *testing.T) {
+		r := newTestConjurConnectReconciler(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "conjur-connect",
+					Namespace: "unlabeled",
+					Labels:    map[string]string{managedByLabelKey: managedByLabelValue},
+				},
+			},
+		)
+
+		assert.NoError(t, r.reconcile(ctx, "unlabeled"))
+
+		_, err := r.Client.CoreV1().ConfigMaps("unlabeled").Get(ctx, "conjur-connect", metav1.GetOptions{})
+		assert.True(t, k8serrors.IsNotFound(err))
+	})
+}
+
+func TestConjurConnectReconcilerLeaderElection(t *testing.T) {
+	r := newTestConjurConnectReconciler(
+		labeledNamespace("app"),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "conjur-connect",
+				Namespace: "unlabeled",
+				Labels:    map[string]string{managedByLabelKey: managedByLabelValue},
+			},
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.RunAsLeader(ctx, "injectors", "injector-0")
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, err := r.Client.CoreV1().ConfigMaps("app").Get(context.Background(), "conjur-connect", metav1.GetOptions{})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	// The ConfigMap left behind in the unlabeled namespace is swept at startup
+	assert.Eventually(t, func() bool {
+		_, err := r.Client.CoreV1().ConfigMaps("unlabeled").Get(context.Background(), "conjur-connect", metav1.GetOptions{})
+		return k8serrors.IsNotFound(err)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lease, err := r.Client.CoordinationV1().Leases("injectors").Get(
+		context.Background(),
+		"cyberark-sidecar-injector-conjur-connect",
+		metav1.GetOptions{},
+	)
+	if assert.NoError(t, err) && assert.NotNil(t, lease.Spec.HolderIdentity) {
+		assert.Equal(t, "injector-0", *lease.Spec.HolderIdentity)
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
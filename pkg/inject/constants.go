@@ -1,29 +1,62 @@
 package inject
 
 const (
-	annotationConjurAuthConfigKey     = "conjur.org/conjurAuthConfig"
-	annotationConjurConnConfigKey     = "conjur.org/conjurConnConfig"
-	annotationContainerNameKey        = "conjur.org/container-name"
-	annotationContainerModeKey        = "conjur.org/container-mode"
+	annotationConjurAuthConfigKey    = "conjur.org/conjurAuthConfig"
+	annotationConjurConnConfigKey    = "conjur.org/conjurConnConfig"
+	annotationContainerNameKey       = "conjur.org/container-name"
+	annotationContainerModeKey       = "conjur.org/container-mode"
 	annotationConjurInjectVolumesKey = "conjur.org/conjur-inject-volumes"
-	annotationInjectKey               = "conjur.org/inject"
-	annotationInjectTypeKey           = "conjur.org/inject-type"
-	annotationSecretlessConfigKey     = "conjur.org/secretless-config"
-	annotationSecretlessCRDSuffixKey  = "conjur.org/secretless-CRD-suffix"
-	annotationStatusKey               = "conjur.org/status"
-	annotationContainerImageKey       = "conjur.org/container-image"
-	annotationSecretsDestinationKey   = "conjur.org/secrets-destination"
+	// Deprecated alias of annotationConjurInjectVolumesKey
+	annotationConjurTokenReceiversKey  = "conjur.org/conjur-token-receivers"
+	annotationInjectKey                = "conjur.org/inject"
+	annotationInjectTypeKey            = "conjur.org/inject-type"
+	annotationSecretlessConfigKey      = "conjur.org/secretless-config"
+	annotationSecretlessCRDSuffixKey   = "conjur.org/secretless-CRD-suffix"
+	annotationStatusKey                = "conjur.org/status"
+	annotationContainerImageKey        = "conjur.org/container-image"
+	annotationSecretsDestinationKey    = "conjur.org/secrets-destination"
+	annotationK8sSecretsKey            = "conjur.org/k8s-secrets"
+	annotationInjectionProfileKey      = "conjur.org/injection-profile"
+	annotationConjurVolumeMountsKey    = "conjur.org/conjur-volume-mounts"
+	annotationRestartAppViaLivenessKey = "conjur.org/restart-app-via-liveness"
+	annotationWaitForSecretsKey        = "conjur.org/wait-for-secrets"
+	annotationWaitForSecretsTimeoutKey = "conjur.org/wait-for-secrets-timeout"
+	annotationLivenessProbeKey         = "conjur.org/liveness-probe"
+	annotationReadinessProbeKey        = "conjur.org/readiness-probe"
+	annotationStartupProbeKey          = "conjur.org/startup-probe"
+	annotationDefaultProbesKey         = "conjur.org/default-probes"
+	annotationSecretlessListenerEnvKey = "conjur.org/secretless-listener-env"
+	// Set on the pod templates of workloads, and kept on their pods
+	annotationSidecarImagesHashKey = "conjur.org/sidecar-images-hash"
+	// Provenance of the injection, set on injected pods
+	annotationInjectorVersionKey = "conjur.org/injector-version"
+	annotationInjectedTypesKey   = "conjur.org/injected-types"
+	annotationInjectedImagesKey  = "conjur.org/injected-images"
+	annotationInjectedVolumesKey = "conjur.org/injected-volumes"
+	annotationInjectedConfigKey  = "conjur.org/injected-config-hash"
+	annotationInjectedAtKey      = "conjur.org/injected-at"
 )
+
 // These annotations are only used for sidecar injector and not passed on to the
 // injected container
-var sidecarInjectorAnnot = []string {
+var sidecarInjectorAnnot = []string{
 	annotationConjurAuthConfigKey,
 	annotationConjurConnConfigKey,
 	annotationContainerNameKey,
 	annotationConjurInjectVolumesKey,
+	annotationConjurTokenReceiversKey,
 	annotationInjectKey,
 	annotationInjectTypeKey,
 	annotationSecretlessConfigKey,
 	annotationSecretlessCRDSuffixKey,
 	annotationContainerImageKey,
-}
\ No newline at end of file
+	annotationInjectionProfileKey,
+	annotationConjurVolumeMountsKey,
+	annotationRestartAppViaLivenessKey,
+	annotationWaitForSecretsKey,
+	annotationWaitForSecretsTimeoutKey,
+	annotationLivenessProbeKey,
+	annotationReadinessProbeKey,
+	annotationStartupProbeKey,
+	annotationSecretlessListenerEnvKey,
+}
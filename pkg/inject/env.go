@@ -0,0 +1,153 @@
+package inject
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Prefixes of the annotations setting environment variables of the injected
+// containers, followed by the variable name, e.g. `conjur.org/env.LOG_LEVEL`
+const (
+	annotationEnvPrefix              = "conjur.org/env."
+	annotationEnvFromConfigMapPrefix = "conjur.org/env-from-configmap."
+	annotationEnvFromSecretPrefix    = "conjur.org/env-from-secret."
+)
+
+// EnvConfig holds the injector-wide settings of the environment variables of
+// injected containers
+type EnvConfig struct {
+	// Variables set on every injected container, overriding those generated
+	// for its inject type
+	Defaults []corev1.EnvVar `json:"defaults,omitempty"`
+	// Security-sensitive variables that pod annotations may nevertheless set
+	AllowedOverrides []string `json:"allowedOverrides,omitempty"`
+}
+
+func (config EnvConfig) validate() error {
+	for _, envVar := range config.Defaults {
+		if errs := validation.IsEnvVarName(envVar.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid default environment variable name %q: %s", envVar.Name, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// isSensitiveEnvVar tells whether a variable configures the connection to
+// Conjur, which pod annotations must not redirect unless allowed
+func isSensitiveEnvVar(name string) bool {
+	return strings.HasPrefix(name, "CONJUR_")
+}
+
+// getAnnotationEnvVars returns the environment variables requested by the
+// `conjur.org/env*` annotations, sorted by name
+func getAnnotationEnvVars(
+	annotations map[string]string,
+	allowedOverrides []string,
+) ([]corev1.EnvVar, error) {
+	allowed := map[string]bool{}
+	for _, name := range allowedOverrides {
+		allowed[name] = true
+	}
+
+	envVars := map[string]corev1.EnvVar{}
+	for key, value := range annotations {
+		var envVar corev1.EnvVar
+		switch {
+		case strings.HasPrefix(key, annotationEnvPrefix):
+			envVar = envVarFromLiteral(strings.TrimPrefix(key, annotationEnvPrefix), value)
+		case strings.HasPrefix(key, annotationEnvFromConfigMapPrefix):
+			name, ref, err := parseEnvVarRef(key, value)
+			if err != nil {
+				return nil, err
+			}
+			envVar = corev1.EnvVar{
+				Name: strings.TrimPrefix(key, annotationEnvFromConfigMapPrefix),
+				ValueFrom: &corev1.EnvVarSource{
+					ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Key:                  ref,
+					},
+				},
+			}
+		case strings.HasPrefix(key, annotationEnvFromSecretPrefix):
+			name, ref, err := parseEnvVarRef(key, value)
+			if err != nil {
+				return nil, err
+			}
+			envVar = corev1.EnvVar{
+				Name: strings.TrimPrefix(key, annotationEnvFromSecretPrefix),
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Key:                  ref,
+					},
+				},
+			}
+		default:
+			continue
+		}
+
+		if errs := validation.IsEnvVarName(envVar.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid environment variable name in %s annotation: %s", key, strings.Join(errs, ", "))
+		}
+		if isSensitiveEnvVar(envVar.Name) && !allowed[envVar.Name] {
+			return nil, fmt.Errorf("environment variable %s can't be set by annotation %s", envVar.Name, key)
+		}
+		if _, ok := envVars[envVar.Name]; ok {
+			return nil, fmt.Errorf("environment variable %s is set by more than one annotation", envVar.Name)
+		}
+		envVars[envVar.Name] = envVar
+	}
+
+	var result []corev1.EnvVar
+	for _, envVar := range envVars {
+		result = append(result, envVar)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// parseEnvVarRef splits the `<name>/<key>` value of an env-from annotation
+func parseEnvVarRef(annotation, value string) (string, string, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%s annotation value (%s) must be of the form <name>/<key>", annotation, value)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// applyEnvVars sets the environment variables on all the containers of the
+// PatchConfig, replacing any variable of the same name
+func applyEnvVars(sidecarConfig *PatchConfig, envVars []corev1.EnvVar) {
+	for _, containers := range [][]corev1.Container{
+		sidecarConfig.InitContainers,
+		sidecarConfig.Containers,
+	} {
+		for i := range containers {
+			for _, envVar := range envVars {
+				containers[i].Env = setEnvVar(containers[i].Env, envVar)
+			}
+		}
+	}
+}
+
+// setEnvVar replaces the variable of the same name, or appends it
+func setEnvVar(env []corev1.EnvVar, envVar corev1.EnvVar) []corev1.EnvVar {
+	for i := range env {
+		if env[i].Name == envVar.Name {
+			env[i] = envVar
+			return env
+		}
+	}
+
+	return append(env, envVar)
+}
@@ -0,0 +1,142 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetAnnotationEnvVars(t *testing.T) {
+	var testCases = []struct {
+		description      string
+		annotations      map[string]string
+		allowedOverrides []string
+		expected         []corev1.EnvVar
+		errContains      string
+	}{
+		{
+			description: "literal, ConfigMap and Secret values",
+			annotations: map[string]string{
+				"conjur.org/env.LOG_LEVEL":                "debug",
+				"conjur.org/env-from-configmap.NO_PROXY":  "proxy-config/no-proxy",
+				"conjur.org/env-from-secret.HTTPS_PROXY":  "proxy-credentials/url",
+				"conjur.org/secretless-config":            "ignored",
+				"conjur.org/secrets-provider.env.IGNORED": "ignored",
+			},
+			expected: []corev1.EnvVar{
+				{
+					Name: "HTTPS_PROXY",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-credentials"},
+							Key:                  "url",
+						},
+					},
+				},
+				{Name: "LOG_LEVEL", Value: "debug"},
+				{
+					Name: "NO_PROXY",
+					ValueFrom: &corev1.EnvVarSource{
+						ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-config"},
+							Key:                  "no-proxy",
+						},
+					},
+				},
+			},
+		},
+		{
+			description: "sensitive variable",
+			annotations: map[string]string{
+				"conjur.org/env.CONJUR_AUTHN_URL": "https://attacker.example.com",
+			},
+			errContains: "environment variable CONJUR_AUTHN_URL can't be set by annotation conjur.org/env.CONJUR_AUTHN_URL",
+		},
+		{
+			description: "allowed sensitive variable",
+			annotations: map[string]string{
+				"conjur.org/env.CONJUR_LOG_LEVEL": "debug",
+			},
+			allowedOverrides: []string{"CONJUR_LOG_LEVEL"},
+			expected:         []corev1.EnvVar{{Name: "CONJUR_LOG_LEVEL", Value: "debug"}},
+		},
+		{
+			description: "invalid reference",
+			annotations: map[string]string{
+				"conjur.org/env-from-secret.TOKEN": "token",
+			},
+			errContains: "conjur.org/env-from-secret.TOKEN annotation value (token) must be of the form <name>/<key>",
+		},
+		{
+			description: "invalid name",
+			annotations: map[string]string{
+				"conjur.org/env.1NVALID": "value",
+			},
+			errContains: "invalid environment variable name in conjur.org/env.1NVALID annotation",
+		},
+		{
+			description: "variable set twice",
+			annotations: map[string]string{
+				"conjur.org/env.LOG_LEVEL":                "debug",
+				"conjur.org/env-from-configmap.LOG_LEVEL": "logging/level",
+			},
+			errContains: "environment variable LOG_LEVEL is set by more than one annotation",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			envVars, err := getAnnotationEnvVars(tc.annotations, tc.allowedOverrides)
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, envVars)
+		})
+	}
+}
+
+func TestEnvInjection(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.ConfigFile.Env = EnvConfig{
+		Defaults: []corev1.EnvVar{
+			{Name: "LOG_LEVEL", Value: "info"},
+			{Name: "CONTAINER_MODE", Value: "overridden-by-default"},
+		},
+	}
+
+	req, err := newTestAdmissionRequest("./testdata/authenticator-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err = addTestAnnotations(req, map[string]string{
+		"conjur.org/env.LOG_LEVEL": "debug",
+		"conjur.org/env.NO_PROXY":  ".svc,.cluster.local",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+	env := map[string]string{}
+	for _, envVar := range pod.Spec.Containers[len(pod.Spec.Containers)-1].Env {
+		env[envVar.Name] = envVar.Value
+	}
+	assert.Equal(t, "debug", env["LOG_LEVEL"])
+	assert.Equal(t, ".svc,.cluster.local", env["NO_PROXY"])
+	assert.Equal(t, "overridden-by-default", env["CONTAINER_MODE"])
+	assert.Contains(t, env, "CONJUR_AUTHN_TOKEN_FILE")
+}
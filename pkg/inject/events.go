@@ -0,0 +1,183 @@
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the events emitted for the injection outcomes of pods
+const (
+	EventReasonInjected = "SidecarInjected"
+	EventReasonSkipped  = "SidecarInjectionSkipped"
+	EventReasonFailed   = "SidecarInjectionFailed"
+)
+
+// auditAnnotationSkipReason is the audit annotation of the admission
+// responses of skipped pods telling why they were skipped, which the API
+// server adds to its audit log and the skipped event repeats
+const auditAnnotationSkipReason = "skip-reason"
+
+const (
+	// eventSourceComponent is the source of the events emitted by the injector
+	eventSourceComponent = "cyberark-sidecar-injector"
+	// Events are rate-limited per owner, event type and reason, as keyed by
+	// eventSpamKey: a burst of eventBurst events, then one event per minute
+	eventBurst = 10
+	eventQPS   = 1. / 60.
+)
+
+// InjectionEventRecorder emits Kubernetes Events describing the injection
+// outcomes of pods. Pods don't exist yet when they are admitted, so the events
+// are emitted on their controller, e.g. their ReplicaSet or Job, where
+// developers without access to the injector logs can see them.
+type InjectionEventRecorder struct {
+	Recorder record.EventRecorder // Destination of the events
+}
+
+// NewInjectionEventRecorder returns an InjectionEventRecorder sending
+// rate-limited events to the Kubernetes API, and a function stopping it
+func NewInjectionEventRecorder(client kubernetes.Interface) (*InjectionEventRecorder, func()) {
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize:   eventBurst,
+		QPS:         eventQPS,
+		SpamKeyFunc: eventSpamKey,
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(""),
+	})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+		Component: eventSourceComponent,
+	})
+
+	return &InjectionEventRecorder{Recorder: recorder}, broadcaster.Shutdown
+}
+
+// eventSpamKey is the key events are rate-limited by. Unlike the default key
+// of client-go, it includes the reason, so that a burst of skipped pods
+// doesn't hide the injected pods of the same controller, whose events are
+// both Normal ones.
+func eventSpamKey(event *corev1.Event) string {
+	return strings.Join([]string{
+		event.Source.Component,
+		event.Source.Host,
+		event.InvolvedObject.Kind,
+		event.InvolvedObject.Namespace,
+		event.InvolvedObject.Name,
+		string(event.InvolvedObject.UID),
+		event.InvolvedObject.APIVersion,
+		event.Type,
+		event.Reason,
+	}, "")
+}
+
+// Emit emits the event describing the outcome of the admission of a pod on
+// its controller. Pods without a controller, dry runs and pods skipped for no
+// reason worth telling, e.g. not annotated at all, have no event.
+func (recorder *InjectionEventRecorder) Emit(
+	req *admissionv1.AdmissionRequest,
+	res *admissionv1.AdmissionResponse,
+) {
+	if req.Kind.Kind != "Pod" || req.Operation != admissionv1.Create {
+		return
+	}
+	audit := newAuditRecord(req, res)
+	if audit.Owner == nil {
+		return
+	}
+	owner := &corev1.ObjectReference{
+		APIVersion: audit.Owner.APIVersion,
+		Kind:       audit.Owner.Kind,
+		Namespace:  audit.Namespace,
+		Name:       audit.Owner.Name,
+		UID:        audit.Owner.UID,
+	}
+
+	var eventType, reason, message string
+	switch audit.Outcome {
+	case AuditOutcomeInjected:
+		images := make([]string, len(audit.Images))
+		for i, image := range audit.Images {
+			images[i] = image.InjectType + "=" + image.Image
+		}
+		eventType, reason = corev1.EventTypeNormal, EventReasonInjected
+		message = fmt.Sprintf(
+			"Injected %s sidecar(s) into pod %s: %s",
+			strings.Join(audit.InjectTypes, ","),
+			audit.Name,
+			strings.Join(images, ","),
+		)
+	case AuditOutcomeDenied:
+		eventType, reason = corev1.EventTypeWarning, EventReasonFailed
+		message = fmt.Sprintf(
+			"Pod %s was rejected: %s. Fix the %s annotations of the pod template, "+
+				"or remove %s to create the pod without sidecars.",
+			audit.Name,
+			audit.Reason,
+			conjurAnnotationPrefix+"*",
+			annotationInjectKey,
+		)
+	case AuditOutcomeSkipped:
+		skipped := res.AuditAnnotations[auditAnnotationSkipReason]
+		if skipped == "" {
+			return
+		}
+		eventType, reason = corev1.EventTypeNormal, EventReasonSkipped
+		message = fmt.Sprintf("Sidecars weren't injected into pod %s: %s", audit.Name, skipped)
+	default:
+		return
+	}
+
+	newSideEffects(req).write(
+		fmt.Sprintf("%s event on %s %s/%s", reason, owner.Kind, owner.Namespace, owner.Name),
+		func() error {
+			recorder.Recorder.Event(owner, eventType, reason, message)
+			return nil
+		},
+	)
+}
+
+// skipReason returns why a pod requesting injection was skipped by the
+// admission, given the annotations of its template and its metadata once the
+// injection profile, policy and namespace defaults are merged in. Pods that
+// don't request injection at all have no reason.
+func skipReason(templateAnnotations map[string]string, metadata *metav1.ObjectMeta) string {
+	injectValue, injectErr := getAnnotation(metadata, annotationInjectKey)
+	for _, ignored := range ignoredNamespaces {
+		if metadata.Namespace == ignored && injectErr == nil {
+			return fmt.Sprintf("pods in namespace %s are never injected", metadata.Namespace)
+		}
+	}
+
+	if status, _ := getAnnotation(metadata, annotationStatusKey); strings.ToLower(status) == "injected" {
+		return fmt.Sprintf("the pod template is annotated %s=%s, remove it to inject sidecars", annotationStatusKey, status)
+	}
+
+	// Pods opted out by the defaults alone aren't worth telling about, only
+	// those whose template has annotations of its own
+	if len(conjurAnnotations(templateAnnotations)) == 0 {
+		return ""
+	}
+	if injectErr == nil {
+		if annotationEnabled(metadata, annotationInjectKey) {
+			return ""
+		}
+		return fmt.Sprintf(
+			"%s=%q doesn't request injection, set it to \"true\" to inject sidecars",
+			annotationInjectKey,
+			injectValue,
+		)
+	}
+	return fmt.Sprintf(
+		"the pod template has %s annotations but not %s, set it to \"true\" to inject sidecars",
+		conjurAnnotationPrefix+"*",
+		annotationInjectKey,
+	)
+}
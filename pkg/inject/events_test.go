@@ -0,0 +1,233 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestInjectionEventRecorder(t *testing.T) {
+	controller := true
+	owner := metav1.OwnerReference{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Name:       "db-migration",
+		UID:        "c5b4bd8b-5f29-11e8-8a3c-36e6bb280816",
+		Controller: &controller,
+	}
+	dryRun := true
+
+	var testCases = []struct {
+		description string
+		podPath     string
+		annotations map[string]string
+		namespace   string
+		// Annotations of the namespace, used as defaults
+		namespaceDefaults map[string]string
+		unannotated       bool
+		noOwner           bool
+		dryRun            *bool
+		expected          []string
+	}{
+		{
+			description: "injected pod",
+			podPath:     "./testdata/multi-type-annotated-pod.json",
+			expected: []string{
+				"Normal SidecarInjected Injected secretless,secrets-provider sidecar(s) into pod nginx-deployment-6c54bd5869-: " +
+					"secretless=secretless-image,secrets-provider=custom-secrets-provider-image",
+			},
+		},
+		{
+			description: "failed injection",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			annotations: map[string]string{"conjur.org/inject-type": "unknown"},
+			expected: []string{
+				"Warning SidecarInjectionFailed Pod nginx-deployment-6c54bd5869- was rejected: " +
+					"Mutation failed for pod , in namespace dummy, due to invalid inject type annotation value = unknown. " +
+					"Fix the conjur.org/* annotations of the pod template, or remove conjur.org/inject to create the pod without sidecars.",
+			},
+		},
+		{
+			description: "injection not requested",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			annotations: map[string]string{"conjur.org/inject": "no"},
+			expected: []string{
+				`Normal SidecarInjectionSkipped Sidecars weren't injected into pod nginx-deployment-6c54bd5869-: ` +
+					`conjur.org/inject="no" doesn't request injection, set it to "true" to inject sidecars`,
+			},
+		},
+		{
+			description: "already injected",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			annotations: map[string]string{"conjur.org/status": "injected"},
+			expected: []string{
+				"Normal SidecarInjectionSkipped Sidecars weren't injected into pod nginx-deployment-6c54bd5869-: " +
+					"the pod template is annotated conjur.org/status=injected, remove it to inject sidecars",
+			},
+		},
+		{
+			description: "ignored namespace",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			namespace:   metav1.NamespaceSystem,
+			expected: []string{
+				"Normal SidecarInjectionSkipped Sidecars weren't injected into pod nginx-deployment-6c54bd5869-: " +
+					"pods in namespace kube-system are never injected",
+			},
+		},
+		{
+			description: "inject annotation missing",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			annotations: map[string]string{"conjur.org/inject": ""},
+			expected: []string{
+				"Normal SidecarInjectionSkipped Sidecars weren't injected into pod nginx-deployment-6c54bd5869-: " +
+					`the pod template has conjur.org/* annotations but not conjur.org/inject, set it to "true" to inject sidecars`,
+			},
+		},
+		{
+			description: "injection declined by namespace defaults",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			annotations: map[string]string{"conjur.org/inject": ""},
+			namespaceDefaults: map[string]string{
+				"conjur.org/inject": "no",
+			},
+			expected: []string{
+				`Normal SidecarInjectionSkipped Sidecars weren't injected into pod nginx-deployment-6c54bd5869-: ` +
+					`conjur.org/inject="no" doesn't request injection, set it to "true" to inject sidecars`,
+			},
+		},
+		{
+			description: "ignored namespace requesting injection by default",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			annotations: map[string]string{"conjur.org/inject": ""},
+			namespace:   metav1.NamespaceSystem,
+			namespaceDefaults: map[string]string{
+				"conjur.org/inject": "true",
+			},
+			expected: []string{
+				"Normal SidecarInjectionSkipped Sidecars weren't injected into pod nginx-deployment-6c54bd5869-: " +
+					"pods in namespace kube-system are never injected",
+			},
+		},
+		{
+			description: "pod not annotated, injection declined by namespace defaults",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			unannotated: true,
+			namespaceDefaults: map[string]string{
+				"conjur.org/inject": "no",
+			},
+		},
+		{
+			description: "pod not annotated",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			unannotated: true,
+		},
+		{
+			description: "pod without controller",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			noOwner:     true,
+		},
+		{
+			description: "dry run",
+			podPath:     "./testdata/authenticator-annotated-pod.json",
+			dryRun:      &dryRun,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req, err := newTestOwnedAdmissionRequest(tc.podPath, owner)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var pod corev1.Pod
+			if !assert.NoError(t, json.Unmarshal(req.Object.Raw, &pod)) {
+				return
+			}
+			for key, value := range tc.annotations {
+				if value == "" {
+					delete(pod.Annotations, key)
+					continue
+				}
+				pod.Annotations[key] = value
+			}
+			if tc.unannotated {
+				pod.Annotations = nil
+			}
+			if tc.namespace != "" {
+				pod.Namespace = tc.namespace
+				req.Namespace = tc.namespace
+			}
+			if tc.noOwner {
+				pod.OwnerReferences = nil
+			}
+			if req.Object.Raw, err = json.Marshal(pod); !assert.NoError(t, err) {
+				return
+			}
+			req.DryRun = tc.dryRun
+			sidecarInjectorConfig := newTestSidecarInjectorConfig()
+			if tc.namespaceDefaults != nil {
+				sidecarInjectorConfig.NamespaceLister = newTestNamespaceLister(&corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{Name: req.Namespace, Annotations: tc.namespaceDefaults},
+				})
+			}
+			res := HandleAdmissionRequest(sidecarInjectorConfig, req)
+
+			fakeRecorder := record.NewFakeRecorder(10)
+			(&InjectionEventRecorder{Recorder: fakeRecorder}).Emit(req, &res)
+			close(fakeRecorder.Events)
+
+			var events []string
+			for event := range fakeRecorder.Events {
+				events = append(events, event)
+			}
+			assert.Equal(t, tc.expected, events)
+		})
+	}
+
+	// Only the creation of pods has events
+	req, err := newTestOwnedAdmissionRequest("./testdata/authenticator-annotated-pod.json", owner)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.Operation = admissionv1.Update
+	res := HandleAdmissionRequest(newTestSidecarInjectorConfig(), req)
+	fakeRecorder := record.NewFakeRecorder(10)
+	(&InjectionEventRecorder{Recorder: fakeRecorder}).Emit(req, &res)
+	assert.Empty(t, fakeRecorder.Events)
+}
+
+// TestEventSpamKey checks that events of the same controller are rate-limited
+// per reason
+func TestEventSpamKey(t *testing.T) {
+	newEvent := func(reason, message string) *corev1.Event {
+		return &corev1.Event{
+			Source: corev1.EventSource{Component: eventSourceComponent},
+			InvolvedObject: corev1.ObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Namespace:  "dummy",
+				Name:       "nginx-deployment-6c54bd5869",
+			},
+			Type:    corev1.EventTypeNormal,
+			Reason:  reason,
+			Message: message,
+		}
+	}
+
+	// Events differing by their message only are rate-limited together
+	assert.Equal(
+		t,
+		eventSpamKey(newEvent(EventReasonSkipped, "pod a")),
+		eventSpamKey(newEvent(EventReasonSkipped, "pod b")),
+	)
+	assert.NotEqual(
+		t,
+		eventSpamKey(newEvent(EventReasonSkipped, "pod a")),
+		eventSpamKey(newEvent(EventReasonInjected, "pod a")),
+	)
+}
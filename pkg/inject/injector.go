@@ -0,0 +1,288 @@
+package inject
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InjectionRequest carries the admitted pod, with its annotations already
+// resolved, to an Injector
+type InjectionRequest struct {
+	Pod       *corev1.Pod
+	Namespace string
+
+	ContainerMode string
+	ContainerName string
+	// Names of the pod containers that receive the volumes shared by the
+	// injected containers
+	InjectVolumes []string
+	// How the shared volumes are mounted into the receiving containers, by
+	// container name
+	MountSpecs map[string]MountSpec
+
+	Config SidecarInjectorConfig
+}
+
+// annotation returns the value of a pod annotation
+func (req InjectionRequest) annotation(key string) (string, error) {
+	return getAnnotation(&req.Pod.ObjectMeta, key)
+}
+
+// containerImage returns the image set by annotation, or the given default
+func (req InjectionRequest) containerImage(defaultImage string) string {
+	image, err := req.annotation(annotationContainerImageKey)
+	if err != nil {
+		return defaultImage
+	}
+
+	return image
+}
+
+// Injector generates the containers and volumes added to a pod for one
+// value of the `conjur.org/inject-type` annotation
+type Injector interface {
+	Inject(req InjectionRequest) (*PatchConfig, error)
+}
+
+// builtinInjectors are the inject types supported out of the box. They can't
+// be shadowed by operator-defined templates.
+var builtinInjectors = map[string]Injector{
+	"secretless":       secretlessInjector{},
+	"authenticator":    authenticatorInjector{},
+	"secrets-provider": secretsProviderInjector{},
+}
+
+// lookupInjector returns the Injector for an inject type, looking up the
+// built-ins first and then the templates of the configuration file
+func lookupInjector(config ConfigFile, injectType string) (Injector, bool) {
+	if injector, ok := builtinInjectors[injectType]; ok {
+		return injector, true
+	}
+	if template, ok := config.Templates[injectType]; ok {
+		return templateInjector{name: injectType, template: template}, true
+	}
+
+	return nil, false
+}
+
+// validateContainerMode rejects container modes other than sidecar and init
+func validateContainerMode(containerMode string) error {
+	switch containerMode {
+	case "sidecar", "init", "":
+		return nil
+	default:
+		return fmt.Errorf("%s value (%s) not supported", annotationContainerModeKey, containerMode)
+	}
+}
+
+type secretlessInjector struct{}
+
+func (secretlessInjector) Inject(req InjectionRequest) (*PatchConfig, error) {
+	secretlessConfig, err := req.annotation(annotationSecretlessConfigKey)
+	if err != nil {
+		return nil, err
+	}
+
+	secretlessCRDSuffix, _ := req.annotation(annotationSecretlessCRDSuffixKey)
+	conjurConnConfigMapName, _ := req.annotation(annotationConjurConnConfigKey)
+	conjurAuthConfigMapName, _ := req.annotation(annotationConjurAuthConfigKey)
+
+	serviceAccountTokenVolumeName, err := getServiceAccountTokenVolumeName(req.Pod)
+	if err != nil {
+		return nil, err
+	}
+
+	sidecarConfig := generateSecretlessSidecarConfig(
+		SecretlessSidecarConfig{
+			secretlessConfig:              secretlessConfig,
+			secretlessCRDSuffix:           secretlessCRDSuffix,
+			conjurConnConfigMapName:       conjurConnConfigMapName,
+			conjurAuthConfigMapName:       conjurAuthConfigMapName,
+			serviceAccountTokenVolumeName: serviceAccountTokenVolumeName,
+			sidecarImage:                  req.containerImage(req.Config.SecretlessContainerImage),
+		},
+	)
+
+	listenerEnv := annotationEnabled(&req.Pod.ObjectMeta, annotationSecretlessListenerEnvKey)
+	if !req.Config.SecretlessListeners {
+		if listenerEnv {
+			return nil, fmt.Errorf(
+				"%s requires the sidecar injector to run with -secretless-listeners",
+				annotationSecretlessListenerEnvKey,
+			)
+		}
+		return sidecarConfig, nil
+	}
+
+	listeners, err := discoverSecretlessListeners(
+		req.Config.KubeClient,
+		req.Config.DynamicClient,
+		req.Namespace,
+		secretlessConfig,
+		secretlessCRDSuffix,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if listenerEnv {
+		envVars, err := secretlessListenerEnvVars(listeners)
+		if err != nil {
+			return nil, err
+		}
+		sidecarConfig.ContainerEnv = secretlessListenerEnv(req.Pod, req.InjectVolumes, envVars)
+	}
+
+	return sidecarConfig, nil
+}
+
+type authenticatorInjector struct{}
+
+func (authenticatorInjector) Inject(req InjectionRequest) (*PatchConfig, error) {
+	conjurAuthConfigMapName, err := req.annotation(annotationConjurAuthConfigKey)
+	if err != nil {
+		return nil, err
+	}
+
+	conjurConnConfigMapName, err := req.annotation(annotationConjurConnConfigKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateContainerMode(req.ContainerMode); err != nil {
+		return nil, err
+	}
+
+	sidecarConfig := generateAuthenticatorSidecarConfig(AuthenticatorSidecarConfig{
+		conjurConnConfigMapName: conjurConnConfigMapName,
+		conjurAuthConfigMapName: conjurAuthConfigMapName,
+		containerMode:           req.ContainerMode,
+		containerName:           req.ContainerName,
+		sidecarImage:            req.containerImage(req.Config.AuthenticatorContainerImage),
+	})
+
+	timeout, err := waitForSecretsTimeout(req.Pod)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		gateStartupOnFile(sidecarConfig, "/run/conjur/conjur-access-token", timeout)
+	}
+
+	sidecarConfig.ContainerVolumeMounts = receiverVolumeMounts(
+		req.InjectVolumes,
+		req.MountSpecs,
+		[]receiverVolume{
+			{name: "conjur-access-token", mountPath: "/run/conjur", readOnly: true, primary: true},
+		},
+	)
+
+	return sidecarConfig, nil
+}
+
+type secretsProviderInjector struct{}
+
+func (secretsProviderInjector) Inject(req InjectionRequest) (*PatchConfig, error) {
+	containerImage, err := req.annotation(annotationContainerImageKey)
+	if err != nil {
+		containerImage = req.Config.SecretsProviderContainerImage
+		log.Printf("Using container image %s", containerImage)
+	}
+
+	if err := validateContainerMode(req.ContainerMode); err != nil {
+		return nil, err
+	}
+
+	secretsDestination, err := req.annotation(annotationSecretsDestinationKey)
+	if err != nil {
+		secretsDestination = "file"
+		log.Printf("Using secrets destination %s", secretsDestination)
+	}
+	// The Role and RoleBinding are written once the patch is validated
+	var rbac *SecretsProviderRBACConfig
+	if secretsDestination == "k8s_secrets" && req.Config.SecretsProviderRBAC {
+		secretNames, err := getK8sSecretNames(req.Pod)
+		if err != nil {
+			return nil, err
+		}
+		rbac = &SecretsProviderRBACConfig{
+			namespace:      req.Namespace,
+			serviceAccount: podServiceAccountName(req.Pod),
+			secretNames:    secretNames,
+		}
+		if err := validateSecretsProviderRBAC(req.Config.KubeClient, *rbac); err != nil {
+			return nil, err
+		}
+	}
+
+	sidecarConfig := generateSecretsProviderSidecarConfig(
+		SecretsProviderSidecarConfig{
+			containerMode:      req.ContainerMode,
+			containerName:      req.ContainerName,
+			sidecarImage:       containerImage,
+			secretsDestination: secretsDestination,
+		},
+	)
+	if rbac != nil {
+		sidecarConfig.SecretsProviderRBAC = []SecretsProviderRBACConfig{*rbac}
+	}
+
+	// Secrets written to Kubernetes Secrets have no volume, leaving the status
+	// as the one volume of the receivers
+	receiverVolumes := []receiverVolume{
+		{name: "conjur-status", mountPath: "/conjur/status", primary: true},
+	}
+	if secretsDestination == "file" {
+		receiverVolumes = []receiverVolume{
+			{name: "conjur-status", mountPath: "/conjur/status"},
+			{name: "conjur-secrets", mountPath: "/conjur/secrets", primary: true},
+		}
+	}
+	sidecarConfig.ContainerVolumeMounts = receiverVolumeMounts(
+		req.InjectVolumes,
+		req.MountSpecs,
+		receiverVolumes,
+	)
+
+	timeout, err := waitForSecretsTimeout(req.Pod)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		gateStartupOnFile(sidecarConfig, "/conjur/status/CONJUR_SECRETS_PROVIDED", timeout)
+	}
+
+	restartApp := annotationEnabled(&req.Pod.ObjectMeta, annotationRestartAppViaLivenessKey)
+	if restartApp && req.ContainerMode == "init" {
+		// An init container provides the secrets once, and never updates them
+		sidecarConfig.Warnings = append(sidecarConfig.Warnings, fmt.Sprintf(
+			"%s ignored, as Secrets Provider runs as an init container and doesn't update the secrets",
+			annotationRestartAppViaLivenessKey,
+		))
+	} else if restartApp {
+		probes, warnings, err := restartViaLivenessProbes(
+			req.Pod,
+			sidecarConfig.ContainerVolumeMounts,
+			req.Config.ConfigFile.RestartAppViaLiveness,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sidecarConfig.ContainerLivenessProbes = probes
+		sidecarConfig.Warnings = append(sidecarConfig.Warnings, warnings...)
+	}
+
+	return sidecarConfig, nil
+}
+
+// splitList splits a comma-separated annotation value, trimming each entry
+func splitList(value string) []string {
+	entries := strings.Split(value, ",")
+	for i := range entries {
+		entries[i] = strings.TrimSpace(entries[i])
+	}
+
+	return entries
+}
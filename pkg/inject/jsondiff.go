@@ -0,0 +1,184 @@
+package inject
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// diffJSON returns the RFC6902 operations turning a JSON value into another,
+// both decoded into interface{}. Objects are compared key by key, so that the
+// patch leaves alone what it doesn't change. Arrays of objects with unique
+// names, such as containers, volumes and environment variables, are matched
+// by name, other arrays by index.
+func diffJSON(path string, from, to interface{}) []rfc6902PatchOperation {
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+
+	switch toValue := to.(type) {
+	case map[string]interface{}:
+		if fromValue, ok := from.(map[string]interface{}); ok {
+			return diffJSONObjects(path, fromValue, toValue)
+		}
+	case []interface{}:
+		if fromValue, ok := from.([]interface{}); ok {
+			return diffJSONArrays(path, fromValue, toValue)
+		}
+	}
+
+	return []rfc6902PatchOperation{
+		{
+			Op:    patchOperationReplace,
+			Path:  path,
+			Value: to,
+		},
+	}
+}
+
+func diffJSONObjects(path string, from, to map[string]interface{}) (patch []rfc6902PatchOperation) {
+	keys := make([]string, 0, len(from)+len(to))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := path + "/" + escapeJSONPointer(key)
+		fromValue, existing := from[key]
+		toValue, ok := to[key]
+		switch {
+		case !ok:
+			patch = append(patch, rfc6902PatchOperation{
+				Op:   patchOperationRemove,
+				Path: keyPath,
+			})
+		case !existing:
+			patch = append(patch, rfc6902PatchOperation{
+				Op:    patchOperationAdd,
+				Path:  keyPath,
+				Value: toValue,
+			})
+		default:
+			patch = append(patch, diffJSON(keyPath, fromValue, toValue)...)
+		}
+	}
+
+	return patch
+}
+
+func diffJSONArrays(path string, from, to []interface{}) (patch []rfc6902PatchOperation) {
+	fromNames, ok := elementNames(from)
+	toNames, toOK := elementNames(to)
+	if !ok || !toOK {
+		return diffJSONArraysByIndex(path, from, to)
+	}
+
+	// Elements are removed from the last one, so that indexes stay valid
+	kept := map[string]bool{}
+	for _, name := range toNames {
+		kept[name] = true
+	}
+	var current []interface{}
+	var currentNames []string
+	for i := len(from) - 1; i >= 0; i-- {
+		if !kept[fromNames[i]] {
+			patch = append(patch, rfc6902PatchOperation{
+				Op:   patchOperationRemove,
+				Path: path + "/" + strconv.Itoa(i),
+			})
+			continue
+		}
+		current = append([]interface{}{from[i]}, current...)
+		currentNames = append([]string{fromNames[i]}, currentNames...)
+	}
+
+	// Then the elements are added or moved in place, in order, and changed
+	for i, name := range toNames {
+		index := -1
+		for j := i; j < len(currentNames); j++ {
+			if currentNames[j] == name {
+				index = j
+				break
+			}
+		}
+
+		elementPath := path + "/" + strconv.Itoa(i)
+		switch {
+		case index == -1:
+			addPath := elementPath
+			if i == len(current) {
+				addPath = path + "/-"
+			}
+			patch = append(patch, rfc6902PatchOperation{
+				Op:    patchOperationAdd,
+				Path:  addPath,
+				Value: to[i],
+			})
+			current = append(current[:i], append([]interface{}{to[i]}, current[i:]...)...)
+			currentNames = append(currentNames[:i], append([]string{name}, currentNames[i:]...)...)
+			continue
+		case index != i:
+			patch = append(patch, rfc6902PatchOperation{
+				Op:   patchOperationMove,
+				From: path + "/" + strconv.Itoa(index),
+				Path: elementPath,
+			})
+			element := current[index]
+			current = append(current[:index], current[index+1:]...)
+			current = append(current[:i], append([]interface{}{element}, current[i:]...)...)
+			currentNames = append(currentNames[:index], currentNames[index+1:]...)
+			currentNames = append(currentNames[:i], append([]string{name}, currentNames[i:]...)...)
+		}
+		patch = append(patch, diffJSON(elementPath, current[i], to[i])...)
+	}
+
+	return patch
+}
+
+func diffJSONArraysByIndex(path string, from, to []interface{}) (patch []rfc6902PatchOperation) {
+	for i := 0; i < len(from) && i < len(to); i++ {
+		patch = append(patch, diffJSON(path+"/"+strconv.Itoa(i), from[i], to[i])...)
+	}
+	for i := len(from) - 1; i >= len(to); i-- {
+		patch = append(patch, rfc6902PatchOperation{
+			Op:   patchOperationRemove,
+			Path: path + "/" + strconv.Itoa(i),
+		})
+	}
+	for i := len(from); i < len(to); i++ {
+		patch = append(patch, rfc6902PatchOperation{
+			Op:    patchOperationAdd,
+			Path:  path + "/-",
+			Value: to[i],
+		})
+	}
+
+	return patch
+}
+
+// elementNames returns the names of the elements of an array, when they are
+// all objects with a unique name
+func elementNames(array []interface{}) ([]string, bool) {
+	names := make([]string, 0, len(array))
+	seen := map[string]bool{}
+	for _, element := range array {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := object["name"].(string)
+		if !ok || name == "" || seen[name] {
+			return nil, false
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names, true
+}
@@ -0,0 +1,72 @@
+package inject
+
+import (
+	"context"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// runAsLeader runs the given function while holding the named Lease in the
+// given namespace, so that only one replica of the injector runs it at a time.
+// The context passed to the function is cancelled when the Lease is lost, and
+// the replica then campaigns again until the context is cancelled. An error
+// returned by the function releases the Lease and is returned.
+func runAsLeader(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespace string,
+	lease string,
+	identity string,
+	run func(ctx context.Context) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      lease,
+				Namespace: namespace,
+			},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Name:            lease,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				log.Printf("Holding Lease %s/%s as %s", namespace, lease, identity)
+				if err := run(leaderCtx); err != nil && leaderCtx.Err() == nil {
+					errs <- err
+					cancel()
+				}
+			},
+			OnStoppedLeading: func() {
+				log.Printf("Released Lease %s/%s as %s", namespace, lease, identity)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package inject
+
+import (
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// File created by Secrets Provider in its status volume when it updated the
+// secrets
+const secretsUpdatedFile = "CONJUR_SECRETS_UPDATED"
+
+// LivenessConfig is the timing of the liveness probes restarting application
+// containers when Secrets Provider rotates secrets
+type LivenessConfig struct {
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int32 `json:"periodSeconds,omitempty"`
+	TimeoutSeconds      int32 `json:"timeoutSeconds,omitempty"`
+}
+
+func (config LivenessConfig) validate() error {
+	if config.InitialDelaySeconds < 0 || config.PeriodSeconds < 0 || config.TimeoutSeconds < 0 {
+		return fmt.Errorf("restartAppViaLiveness timings must not be negative")
+	}
+
+	return nil
+}
+
+// secretsUpdatedCheck returns a shell command that fails once after Secrets
+// Provider updated the secrets. The file Secrets Provider signals updates with
+// is shared by the receiving containers, so it is left in place: each
+// container touches its own marker when it fails, and passes until the file
+// is newer than the marker again.
+func secretsUpdatedCheck(statusPath, containerName string) string {
+	file := path.Join(statusPath, secretsUpdatedFile)
+	marker := path.Join(statusPath, "."+secretsUpdatedFile+"."+containerName)
+	return fmt.Sprintf(
+		"if [ -f %s ] && [ ! %s -nt %s ]; then touch %s; exit 1; fi",
+		file,
+		marker,
+		file,
+		marker,
+	)
+}
+
+// restartViaLivenessProbes returns the liveness probes of the receiving
+// containers that restart them when the secrets are updated. The probes run
+// the check with sh, which the images must provide, as a warning reminds.
+// Containers with their own liveness probe are rejected: restarting them on a
+// single failure would change the meaning of the probe, while keeping its
+// failureThreshold would never restart them, as the check only fails once.
+func restartViaLivenessProbes(
+	pod *corev1.Pod,
+	containerVolumeMounts ContainerVolumeMounts,
+	config LivenessConfig,
+) (map[string]*corev1.Probe, []string, error) {
+	probes := map[string]*corev1.Probe{}
+	var warnings []string
+
+	for _, container := range pod.Spec.Containers {
+		volumeMounts, ok := containerVolumeMounts[container.Name]
+		if !ok {
+			continue
+		}
+
+		statusPath := ""
+		for _, volumeMount := range volumeMounts {
+			if volumeMount.Name == "conjur-status" && !volumeMount.ReadOnly {
+				statusPath = volumeMount.MountPath
+			}
+		}
+		if statusPath == "" {
+			return nil, nil, fmt.Errorf(
+				"%s requires the conjur-status volume to be mounted read-write in container %s",
+				annotationRestartAppViaLivenessKey,
+				container.Name,
+			)
+		}
+		if container.LivenessProbe != nil {
+			return nil, nil, fmt.Errorf(
+				"%s can't restart container %s, which has its own liveness probe: "+
+					"remove the probe or the annotation",
+				annotationRestartAppViaLivenessKey,
+				container.Name,
+			)
+		}
+		check := secretsUpdatedCheck(statusPath, container.Name)
+
+		probes[container.Name] = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				Exec: &corev1.ExecAction{
+					Command: []string{"sh", "-c", check},
+				},
+			},
+			InitialDelaySeconds: config.InitialDelaySeconds,
+			PeriodSeconds:       config.PeriodSeconds,
+			TimeoutSeconds:      config.TimeoutSeconds,
+			FailureThreshold:    1,
+		}
+		warnings = append(warnings, fmt.Sprintf(
+			"%s adds a liveness probe running sh to container %s, whose image must provide it",
+			annotationRestartAppViaLivenessKey,
+			container.Name,
+		))
+	}
+
+	return probes, warnings, nil
+}
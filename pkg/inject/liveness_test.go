@@ -0,0 +1,214 @@
+package inject
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const testSecretsUpdatedCheck = "if [ -f /conjur/status/CONJUR_SECRETS_UPDATED ] && " +
+	"[ ! /conjur/status/.CONJUR_SECRETS_UPDATED.app -nt /conjur/status/CONJUR_SECRETS_UPDATED ]; " +
+	"then touch /conjur/status/.CONJUR_SECRETS_UPDATED.app; exit 1; fi"
+
+func TestRestartViaLivenessProbes(t *testing.T) {
+	statusMounts := []corev1.VolumeMount{
+		{Name: "conjur-status", MountPath: "/conjur/status"},
+		{Name: "conjur-secrets", MountPath: "/conjur/secrets"},
+	}
+	config := LivenessConfig{PeriodSeconds: 5}
+
+	var testCases = []struct {
+		description string
+		container   corev1.Container
+		mounts      []corev1.VolumeMount
+		expected    *corev1.Probe
+		warnings    []string
+		errContains string
+	}{
+		{
+			description: "new probe",
+			container:   corev1.Container{Name: "app"},
+			mounts:      statusMounts,
+			expected: &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{
+					Exec: &corev1.ExecAction{
+						Command: []string{"sh", "-c", testSecretsUpdatedCheck},
+					},
+				},
+				PeriodSeconds:    5,
+				FailureThreshold: 1,
+			},
+			warnings: []string{
+				"conjur.org/restart-app-via-liveness adds a liveness probe running sh to container app, whose image must provide it",
+			},
+		},
+		{
+			description: "existing exec probe",
+			container: corev1.Container{
+				Name: "app",
+				LivenessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						Exec: &corev1.ExecAction{Command: []string{"/bin/healthy", "--quick"}},
+					},
+					PeriodSeconds:    30,
+					FailureThreshold: 3,
+				},
+			},
+			mounts:      statusMounts,
+			errContains: "conjur.org/restart-app-via-liveness can't restart container app, which has its own liveness probe",
+		},
+		{
+			description: "existing HTTP probe",
+			container: corev1.Container{
+				Name: "app",
+				LivenessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(8080)},
+					},
+				},
+			},
+			mounts:      statusMounts,
+			errContains: "conjur.org/restart-app-via-liveness can't restart container app, which has its own liveness probe",
+		},
+		{
+			description: "status volume not mounted",
+			container:   corev1.Container{Name: "app"},
+			mounts:      statusMounts[1:],
+			errContains: "requires the conjur-status volume to be mounted read-write in container app",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			pod := &corev1.Pod{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{tc.container}},
+			}
+
+			probes, warnings, err := restartViaLivenessProbes(
+				pod,
+				ContainerVolumeMounts{"app": tc.mounts},
+				config,
+			)
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.warnings, warnings)
+			assert.Equal(t, tc.expected, probes["app"])
+		})
+	}
+}
+
+func TestRestartAppViaLivenessInjection(t *testing.T) {
+	req, err := newTestAdmissionRequest("./testdata/secrets-provider-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err = addTestAnnotations(req, map[string]string{
+		"conjur.org/restart-app-via-liveness": "true",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequest(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+
+	probe := pod.Spec.Containers[0].LivenessProbe
+	if assert.NotNil(t, probe) {
+		assert.Equal(t, []string{"sh", "-c", secretsUpdatedCheck("/conjur/status", "nginx-1")}, probe.Exec.Command)
+		assert.Equal(t, int32(1), probe.FailureThreshold)
+	}
+	assert.Nil(t, pod.Spec.Containers[1].LivenessProbe)
+	assert.NotContains(t, pod.Annotations, "conjur.org/restart-app-via-liveness")
+}
+
+// TestSecretsUpdatedCheck runs the checks of two containers sharing the status
+// volume, which must both fail once per update of the secrets
+func TestSecretsUpdatedCheck(t *testing.T) {
+	statusPath := t.TempDir()
+	check := func(containerName string) error {
+		return exec.Command("sh", "-c", secretsUpdatedCheck(statusPath, containerName)).Run()
+	}
+	// setMtime stands for the time passing between updates of the secrets
+	setMtime := func(name string, at time.Time) {
+		assert.NoError(t, os.Chtimes(filepath.Join(statusPath, name), at, at))
+	}
+	updateSecrets := func(at time.Time) {
+		if assert.NoError(t, os.WriteFile(filepath.Join(statusPath, secretsUpdatedFile), nil, 0600)) {
+			setMtime(secretsUpdatedFile, at)
+		}
+	}
+
+	assert.NoError(t, check("app"))
+	assert.NoError(t, check("worker"))
+
+	updateSecrets(time.Now().Add(-2 * time.Minute))
+	assert.Error(t, check("app"))
+	assert.NoError(t, check("app"))
+	assert.Error(t, check("worker"))
+	assert.NoError(t, check("worker"))
+
+	setMtime("."+secretsUpdatedFile+".app", time.Now().Add(-time.Minute))
+	setMtime("."+secretsUpdatedFile+".worker", time.Now().Add(-time.Minute))
+	updateSecrets(time.Now().Add(-30 * time.Second))
+	assert.Error(t, check("worker"))
+	assert.Error(t, check("app"))
+	assert.NoError(t, check("app"))
+	assert.NoError(t, check("worker"))
+}
+
+func TestRestartAppViaLivenessInitMode(t *testing.T) {
+	reqJSON, err := newTestAdmissionRequest("./testdata/secrets-provider-init-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	reqJSON, err = addTestAnnotations(reqJSON, map[string]string{
+		"conjur.org/restart-app-via-liveness": "true",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err := NewAdmissionRequest(reqJSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	res := HandleAdmissionRequest(newTestSidecarInjectorConfig(), req)
+	if !assert.Nil(t, res.Result) {
+		return
+	}
+	assert.Contains(
+		t,
+		res.Warnings,
+		"conjur.org/restart-app-via-liveness ignored, as Secrets Provider runs as an init container and doesn't update the secrets",
+	)
+
+	mod, err := applyPatchToAdmissionRequest(reqJSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+	for _, container := range pod.Spec.Containers {
+		assert.Nil(t, container.LivenessProbe)
+	}
+}
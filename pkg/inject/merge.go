@@ -0,0 +1,172 @@
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// parseInjectTypes splits the `conjur.org/inject-type` annotation into its
+// inject types, rejecting empty and repeated entries in a list
+func parseInjectTypes(value string) ([]string, error) {
+	injectTypes := splitList(value)
+	seen := map[string]bool{}
+	for _, injectType := range injectTypes {
+		if injectType == "" && len(injectTypes) > 1 {
+			return nil, fmt.Errorf("empty entry in %s annotation value = %s", annotationInjectTypeKey, value)
+		}
+		if seen[injectType] {
+			return nil, fmt.Errorf("inject type %s repeated in %s annotation", injectType, annotationInjectTypeKey)
+		}
+		seen[injectType] = true
+	}
+
+	return injectTypes, nil
+}
+
+// typeAnnotationPrefix returns the prefix of the annotations overriding the
+// settings of one inject type, e.g. `conjur.org/secrets-provider.`
+func typeAnnotationPrefix(injectType string) string {
+	return conjurAnnotationPrefix + injectType + "."
+}
+
+// annotationsForType returns the pod annotations seen by one inject type,
+// where `conjur.org/<type>.<key>` overrides `conjur.org/<key>`
+func annotationsForType(annotations map[string]string, injectType string) map[string]string {
+	result := map[string]string{}
+	for key, value := range annotations {
+		result[key] = value
+	}
+
+	prefix := typeAnnotationPrefix(injectType)
+	for key, value := range annotations {
+		if strings.HasPrefix(key, prefix) {
+			result[conjurAnnotationPrefix+strings.TrimPrefix(key, prefix)] = value
+		}
+	}
+
+	return result
+}
+
+// stripTypeAnnotations removes the per-type overrides of the annotations
+// that are only used by the sidecar injector
+func stripTypeAnnotations(annotations map[string]string, injectTypes []string) {
+	for _, injectType := range injectTypes {
+		for _, key := range sidecarInjectorAnnot {
+			delete(
+				annotations,
+				typeAnnotationPrefix(injectType)+strings.TrimPrefix(key, conjurAnnotationPrefix),
+			)
+		}
+	}
+}
+
+// mergePatchConfigs merges the PatchConfigs generated for several inject
+// types. Containers must have unique names, within the pod and across the
+// PatchConfigs. Volumes and volume mounts may be shared when they are
+// identical, but conflicting definitions are rejected.
+func mergePatchConfigs(pod *corev1.Pod, sidecarConfigs []*PatchConfig) (*PatchConfig, error) {
+	if len(sidecarConfigs) == 1 {
+		return sidecarConfigs[0], nil
+	}
+
+	containerNames := map[string]bool{}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			containerNames[container.Name] = true
+		}
+	}
+	volumes := map[string]corev1.Volume{}
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = volume
+	}
+
+	merged := &PatchConfig{ContainerVolumeMounts: ContainerVolumeMounts{}}
+	for _, sidecarConfig := range sidecarConfigs {
+		for _, containers := range [][]corev1.Container{sidecarConfig.InitContainers, sidecarConfig.Containers} {
+			for _, container := range containers {
+				if containerNames[container.Name] {
+					return nil, fmt.Errorf("container name %s is used more than once", container.Name)
+				}
+				containerNames[container.Name] = true
+			}
+		}
+		merged.InitContainers = append(merged.InitContainers, sidecarConfig.InitContainers...)
+		merged.Containers = append(merged.Containers, sidecarConfig.Containers...)
+
+		for _, volume := range sidecarConfig.Volumes {
+			existing, ok := volumes[volume.Name]
+			if !ok {
+				volumes[volume.Name] = volume
+				merged.Volumes = append(merged.Volumes, volume)
+				continue
+			}
+			if !equality.Semantic.DeepEqual(existing, volume) {
+				return nil, fmt.Errorf("volume %s is defined more than once with different sources", volume.Name)
+			}
+		}
+
+		for containerName, probe := range sidecarConfig.ContainerLivenessProbes {
+			if merged.ContainerLivenessProbes == nil {
+				merged.ContainerLivenessProbes = map[string]*corev1.Probe{}
+			}
+			if _, ok := merged.ContainerLivenessProbes[containerName]; ok {
+				return nil, fmt.Errorf("liveness probe of container %s is set more than once", containerName)
+			}
+			merged.ContainerLivenessProbes[containerName] = probe
+		}
+		for containerName, envVars := range sidecarConfig.ContainerEnv {
+			if merged.ContainerEnv == nil {
+				merged.ContainerEnv = map[string][]corev1.EnvVar{}
+			}
+			if _, ok := merged.ContainerEnv[containerName]; ok {
+				return nil, fmt.Errorf("environment of container %s is set more than once", containerName)
+			}
+			merged.ContainerEnv[containerName] = envVars
+		}
+		merged.StartFirst = append(merged.StartFirst, sidecarConfig.StartFirst...)
+		merged.Warnings = append(merged.Warnings, sidecarConfig.Warnings...)
+		merged.SecretsProviderRBAC = append(merged.SecretsProviderRBAC, sidecarConfig.SecretsProviderRBAC...)
+
+		for containerName, volumeMounts := range sidecarConfig.ContainerVolumeMounts {
+			for _, volumeMount := range volumeMounts {
+				mounts, err := addVolumeMount(
+					merged.ContainerVolumeMounts[containerName],
+					volumeMount,
+				)
+				if err != nil {
+					return nil, fmt.Errorf("container %s: %v", containerName, err)
+				}
+				merged.ContainerVolumeMounts[containerName] = mounts
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// addVolumeMount appends a volume mount unless an identical one exists,
+// failing when another volume is mounted at the same path
+func addVolumeMount(
+	volumeMounts []corev1.VolumeMount,
+	volumeMount corev1.VolumeMount,
+) ([]corev1.VolumeMount, error) {
+	for _, existing := range volumeMounts {
+		if existing.MountPath != volumeMount.MountPath {
+			continue
+		}
+		if equality.Semantic.DeepEqual(existing, volumeMount) {
+			return volumeMounts, nil
+		}
+		return nil, fmt.Errorf(
+			"volumes %s and %s are both mounted at %s",
+			existing.Name,
+			volumeMount.Name,
+			volumeMount.MountPath,
+		)
+	}
+
+	return append(volumeMounts, volumeMount), nil
+}
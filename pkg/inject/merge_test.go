@@ -0,0 +1,165 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMultipleInjectTypes(t *testing.T) {
+	req, err := newTestAdmissionRequest("./testdata/multi-type-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequest(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+
+	images := map[string]string{}
+	for _, container := range pod.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+	assert.Equal(t, map[string]string{
+		"nginx-1":                           "nginx:1.7.9",
+		"nginx-2":                           "nginx:1.7.9",
+		"secretless":                        "secretless-image",
+		"cyberark-secrets-provider-for-k8s": "custom-secrets-provider-image",
+	}, images)
+
+	var volumes []string
+	for _, volume := range pod.Spec.Volumes {
+		volumes = append(volumes, volume.Name)
+	}
+	assert.Equal(t, []string{
+		"default-token-tq5lq",
+		"secretless-config",
+		"podinfo",
+		"conjur-status",
+		"conjur-secrets",
+	}, volumes)
+
+	assert.Len(t, pod.Annotations["conjur.org/injected-config-hash"], 16)
+	delete(pod.Annotations, "conjur.org/injected-config-hash")
+	assert.Equal(t, map[string]string{
+		"conjur.org/status":           "injected",
+		"conjur.org/injected-at":      "2021-01-01T00:00:00Z",
+		"conjur.org/injected-images":  "secretless=secretless-image,secrets-provider=custom-secrets-provider-image",
+		"conjur.org/injected-types":   "secretless,secrets-provider",
+		"conjur.org/injected-volumes": "secretless-config,podinfo,conjur-status,conjur-secrets",
+		"conjur.org/injector-version": "unset-unset",
+	}, pod.Annotations)
+}
+
+func TestParseInjectTypes(t *testing.T) {
+	injectTypes, err := parseInjectTypes("secretless, secrets-provider")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secretless", "secrets-provider"}, injectTypes)
+
+	_, err = parseInjectTypes("secretless,,secrets-provider")
+	assert.EqualError(t, err, "empty entry in conjur.org/inject-type annotation value = secretless,,secrets-provider")
+
+	_, err = parseInjectTypes("secretless,secretless")
+	assert.EqualError(t, err, "inject type secretless repeated in conjur.org/inject-type annotation")
+}
+
+func TestAnnotationsForType(t *testing.T) {
+	annotations := map[string]string{
+		"conjur.org/container-image":                  "shared-image",
+		"conjur.org/container-name":                   "shared-name",
+		"conjur.org/secrets-provider.container-image": "secrets-provider-image",
+	}
+
+	assert.Equal(
+		t,
+		"secrets-provider-image",
+		annotationsForType(annotations, "secrets-provider")["conjur.org/container-image"],
+	)
+	assert.Equal(
+		t,
+		"shared-image",
+		annotationsForType(annotations, "authenticator")["conjur.org/container-image"],
+	)
+	assert.Equal(t, "shared-image", annotations["conjur.org/container-image"])
+}
+
+func TestMergePatchConfigsConflicts(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}},
+			Volumes:    []corev1.Volume{{Name: "data"}},
+		},
+	}
+	emptyDir := corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+
+	var testCases = []struct {
+		description string
+		configs     []*PatchConfig
+		errContains string
+	}{
+		{
+			description: "shared identical volume",
+			configs: []*PatchConfig{
+				{Volumes: []corev1.Volume{{Name: "shared", VolumeSource: emptyDir}}},
+				{Volumes: []corev1.Volume{{Name: "shared", VolumeSource: emptyDir}}},
+			},
+		},
+		{
+			description: "container name used twice",
+			configs: []*PatchConfig{
+				{Containers: []corev1.Container{{Name: "sidecar"}}},
+				{InitContainers: []corev1.Container{{Name: "sidecar"}}},
+			},
+			errContains: "container name sidecar is used more than once",
+		},
+		{
+			description: "container name used by the pod",
+			configs: []*PatchConfig{
+				{},
+				{Containers: []corev1.Container{{Name: "app"}}},
+			},
+			errContains: "container name app is used more than once",
+		},
+		{
+			description: "volume with different sources",
+			configs: []*PatchConfig{
+				{},
+				{Volumes: []corev1.Volume{{Name: "data", VolumeSource: emptyDir}}},
+			},
+			errContains: "volume data is defined more than once with different sources",
+		},
+		{
+			description: "two volumes mounted at the same path",
+			configs: []*PatchConfig{
+				{ContainerVolumeMounts: ContainerVolumeMounts{
+					"app": {{Name: "one", MountPath: "/conjur"}},
+				}},
+				{ContainerVolumeMounts: ContainerVolumeMounts{
+					"app": {{Name: "two", MountPath: "/conjur"}},
+				}},
+			},
+			errContains: "container app: volumes one and two are both mounted at /conjur",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := mergePatchConfigs(pod, tc.configs)
+			if tc.errContains == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.errContains)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package inject
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// receiverVolume is a volume of an injected container that is also mounted
+// into the receiving containers of the pod
+type receiverVolume struct {
+	name      string
+	mountPath string
+	readOnly  bool
+	// The volume holding the data the receivers are after, e.g. the access
+	// token or the secrets, rather than the status of the injected container
+	primary bool
+}
+
+// MountSpec customizes how the receiver volumes are mounted into one
+// receiving container, as parsed from the `conjur.org/conjur-volume-mounts`
+// annotation, e.g. `app=/var/secrets:ro,worker=/etc/conjur/app.yaml:subPath=app.yaml`
+type MountSpec struct {
+	// Mount path of the primary volume
+	Path string
+	// Overrides the read-only flag of the mounts when set
+	ReadOnly *bool
+	// Mounts a single file of the primary volume at Path
+	SubPath string
+	// Leaves out the status volume
+	FileOnly bool
+	// Mount path of the status volume
+	StatusPath string
+}
+
+// parseMountSpecs parses the `conjur.org/conjur-volume-mounts` annotation,
+// a comma-separated list of `<container>=<path>[:<option>]...` with options
+// `ro`, `rw`, `file-only`, `subPath=<file>` and `status=<path>`
+func parseMountSpecs(value string) (map[string]MountSpec, error) {
+	specs := map[string]MountSpec{}
+	for _, entry := range splitList(value) {
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		containerName := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || containerName == "" {
+			return nil, fmt.Errorf("%s entry (%s) must be of the form <container>=<path>", annotationConjurVolumeMountsKey, entry)
+		}
+		if _, ok := specs[containerName]; ok {
+			return nil, fmt.Errorf("%s sets container %s more than once", annotationConjurVolumeMountsKey, containerName)
+		}
+
+		fields := strings.Split(parts[1], ":")
+		spec := MountSpec{Path: fields[0]}
+		for _, option := range fields[1:] {
+			switch {
+			case option == "ro" || option == "rw":
+				readOnly := option == "ro"
+				spec.ReadOnly = &readOnly
+			case option == "file-only":
+				spec.FileOnly = true
+			case strings.HasPrefix(option, "subPath="):
+				spec.SubPath = strings.TrimPrefix(option, "subPath=")
+				if spec.SubPath == "" || path.IsAbs(spec.SubPath) || strings.Contains(spec.SubPath, "..") {
+					return nil, fmt.Errorf("%s has invalid subPath for container %s", annotationConjurVolumeMountsKey, containerName)
+				}
+			case strings.HasPrefix(option, "status="):
+				spec.StatusPath = strings.TrimPrefix(option, "status=")
+				if !path.IsAbs(spec.StatusPath) {
+					return nil, fmt.Errorf("%s status path for container %s must be absolute", annotationConjurVolumeMountsKey, containerName)
+				}
+			default:
+				return nil, fmt.Errorf("%s has unknown option %s for container %s", annotationConjurVolumeMountsKey, option, containerName)
+			}
+		}
+		if !path.IsAbs(spec.Path) {
+			return nil, fmt.Errorf("%s path for container %s must be absolute", annotationConjurVolumeMountsKey, containerName)
+		}
+		if spec.FileOnly && spec.StatusPath != "" {
+			return nil, fmt.Errorf("%s can't combine file-only and status for container %s", annotationConjurVolumeMountsKey, containerName)
+		}
+		specs[containerName] = spec
+	}
+
+	return specs, nil
+}
+
+// getMountSpecs reads the mount specs of a pod, which may only refer to its
+// receiving containers. Containers can only leave out the status volume when
+// the injected container is an init container: sidecars report through it
+// whether the secrets are ready, e.g. for `conjur.org/wait-for-secrets`.
+func getMountSpecs(pod *corev1.Pod, receivers []string, containerMode string) (map[string]MountSpec, error) {
+	value, err := getAnnotation(&pod.ObjectMeta, annotationConjurVolumeMountsKey)
+	if err != nil {
+		return nil, nil
+	}
+
+	specs, err := parseMountSpecs(value)
+	if err != nil {
+		return nil, err
+	}
+
+	isReceiver := map[string]bool{}
+	for _, name := range receivers {
+		isReceiver[name] = true
+	}
+	for name := range specs {
+		if !isReceiver[name] {
+			return nil, fmt.Errorf(
+				"%s refers to container %s, which isn't listed in %s",
+				annotationConjurVolumeMountsKey,
+				name,
+				annotationConjurInjectVolumesKey,
+			)
+		}
+	}
+	for _, name := range sortedMountSpecNames(specs) {
+		if specs[name].FileOnly && containerMode != "init" {
+			return nil, fmt.Errorf(
+				"%s sets file-only for container %s, which requires %s=init",
+				annotationConjurVolumeMountsKey,
+				name,
+				annotationContainerModeKey,
+			)
+		}
+	}
+
+	return specs, nil
+}
+
+// receiverVolumeMounts returns the volume mounts of the receiving containers,
+// applying their mount specs to the default mounts of the volumes
+func receiverVolumeMounts(
+	receivers []string,
+	specs map[string]MountSpec,
+	volumes []receiverVolume,
+) ContainerVolumeMounts {
+	containerVolumeMounts := ContainerVolumeMounts{}
+	for _, receiveContainerName := range receivers {
+		spec, hasSpec := specs[receiveContainerName]
+
+		var volumeMounts []corev1.VolumeMount
+		for _, volume := range volumes {
+			volumeMount := corev1.VolumeMount{
+				Name:      volume.name,
+				ReadOnly:  volume.readOnly,
+				MountPath: volume.mountPath,
+			}
+			if hasSpec {
+				if volume.primary {
+					volumeMount.MountPath = spec.Path
+					volumeMount.SubPath = spec.SubPath
+				} else if spec.FileOnly {
+					continue
+				} else if spec.StatusPath != "" {
+					volumeMount.MountPath = spec.StatusPath
+				}
+				if spec.ReadOnly != nil {
+					volumeMount.ReadOnly = *spec.ReadOnly
+				}
+			}
+			volumeMounts = append(volumeMounts, volumeMount)
+		}
+		containerVolumeMounts[receiveContainerName] = volumeMounts
+	}
+
+	return containerVolumeMounts
+}
+
+// validateVolumeMounts rejects volume mounts that would be added at a path
+// already used by a mount of the receiving container
+func validateVolumeMounts(pod *corev1.Pod, containerVolumeMounts ContainerVolumeMounts) error {
+	existing := map[string][]corev1.VolumeMount{}
+	for _, containers := range [][]corev1.Container{
+		pod.Spec.InitContainers,
+		pod.Spec.Containers,
+		ephemeralContainersAsContainers(pod.Spec.EphemeralContainers),
+	} {
+		for _, container := range containers {
+			existing[container.Name] = container.VolumeMounts
+		}
+	}
+
+	for _, containerName := range sortedContainerNames(containerVolumeMounts) {
+		mountPaths := map[string]string{}
+		for _, volumeMount := range existing[containerName] {
+			mountPaths[path.Clean(volumeMount.MountPath)] = volumeMount.Name
+		}
+		for _, volumeMount := range containerVolumeMounts[containerName] {
+			mountPath := path.Clean(volumeMount.MountPath)
+			if name, ok := mountPaths[mountPath]; ok {
+				return fmt.Errorf(
+					"volume %s can't be mounted at %s in container %s, which already mounts volume %s there",
+					volumeMount.Name,
+					volumeMount.MountPath,
+					containerName,
+					name,
+				)
+			}
+			mountPaths[mountPath] = volumeMount.Name
+		}
+	}
+
+	return nil
+}
+
+// sortedMountSpecNames returns the container names of mount specs in order,
+// so that validation errors are stable
+func sortedMountSpecNames(specs map[string]MountSpec) []string {
+	var names []string
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// sortedContainerNames returns the container names of a ContainerVolumeMounts
+// in order, so that validation errors are stable
+func sortedContainerNames(containerVolumeMounts ContainerVolumeMounts) []string {
+	var names []string
+	for name := range containerVolumeMounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,191 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseMountSpecs(t *testing.T) {
+	readOnly := true
+	readWrite := false
+
+	var testCases = []struct {
+		description string
+		value       string
+		expected    map[string]MountSpec
+		errContains string
+	}{
+		{
+			description: "paths and options",
+			value:       "app=/var/secrets:ro, worker=/etc/conjur:file-only,api=/etc/app/app.yaml:subPath=app.yaml:rw,job=/run/token:status=/run/token-status",
+			expected: map[string]MountSpec{
+				"app":    {Path: "/var/secrets", ReadOnly: &readOnly},
+				"worker": {Path: "/etc/conjur", FileOnly: true},
+				"api":    {Path: "/etc/app/app.yaml", SubPath: "app.yaml", ReadOnly: &readWrite},
+				"job":    {Path: "/run/token", StatusPath: "/run/token-status"},
+			},
+		},
+		{
+			description: "missing path",
+			value:       "app",
+			errContains: "entry (app) must be of the form <container>=<path>",
+		},
+		{
+			description: "relative path",
+			value:       "app=secrets",
+			errContains: "path for container app must be absolute",
+		},
+		{
+			description: "unknown option",
+			value:       "app=/secrets:readonly",
+			errContains: "unknown option readonly for container app",
+		},
+		{
+			description: "escaping subPath",
+			value:       "app=/secrets/app.yaml:subPath=../app.yaml",
+			errContains: "invalid subPath for container app",
+		},
+		{
+			description: "container set twice",
+			value:       "app=/secrets,app=/other",
+			errContains: "sets container app more than once",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			specs, err := parseMountSpecs(tc.value)
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, specs)
+		})
+	}
+}
+
+func TestCustomReceiverMounts(t *testing.T) {
+	req, err := newTestAdmissionRequest("./testdata/secrets-provider-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var testCases = []struct {
+		description   string
+		mountSpec     string
+		containerMode string
+		expected      []corev1.VolumeMount
+	}{
+		{
+			description: "custom read-only path",
+			mountSpec:   "nginx-1=/var/secrets:ro",
+			expected: []corev1.VolumeMount{
+				{Name: "conjur-status", ReadOnly: true, MountPath: "/conjur/status"},
+				{Name: "conjur-secrets", ReadOnly: true, MountPath: "/var/secrets"},
+			},
+		},
+		{
+			description: "custom status path",
+			mountSpec:   "nginx-1=/var/secrets:status=/var/conjur-status",
+			expected: []corev1.VolumeMount{
+				{Name: "conjur-status", MountPath: "/var/conjur-status"},
+				{Name: "conjur-secrets", MountPath: "/var/secrets"},
+			},
+		},
+		{
+			description:   "single secret file",
+			mountSpec:     "nginx-1=/etc/app/app.yaml:subPath=app.yaml:file-only",
+			containerMode: "init",
+			expected: []corev1.VolumeMount{
+				{Name: "conjur-secrets", MountPath: "/etc/app/app.yaml", SubPath: "app.yaml"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			annotations := map[string]string{
+				"conjur.org/conjur-volume-mounts": tc.mountSpec,
+			}
+			if tc.containerMode != "" {
+				annotations["conjur.org/container-mode"] = tc.containerMode
+			}
+			req, err := addTestAnnotations(req, annotations)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			mod, err := applyPatchToAdmissionRequest(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var pod corev1.Pod
+			if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+				return
+			}
+
+			assert.Equal(t, tc.expected, pod.Spec.Containers[0].VolumeMounts[1:])
+			assert.NotContains(t, pod.Annotations, "conjur.org/conjur-volume-mounts")
+		})
+	}
+
+	t.Run("conflict with existing mount", func(t *testing.T) {
+		req, err := addTestAnnotations(req, map[string]string{
+			"conjur.org/conjur-volume-mounts": "nginx-1=/var/run/secrets/kubernetes.io/serviceaccount/",
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		_, err = applyPatchToAdmissionRequest(req)
+		if assert.Error(t, err) {
+			assert.Contains(
+				t,
+				err.Error(),
+				"volume conjur-secrets can't be mounted at /var/run/secrets/kubernetes.io/serviceaccount/ in container nginx-1, which already mounts volume default-token-tq5lq there",
+			)
+		}
+	})
+
+	t.Run("file-only with a sidecar", func(t *testing.T) {
+		req, err := addTestAnnotations(req, map[string]string{
+			"conjur.org/conjur-volume-mounts": "nginx-1=/etc/app/app.yaml:subPath=app.yaml:file-only",
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		_, err = applyPatchToAdmissionRequest(req)
+		if assert.Error(t, err) {
+			assert.Contains(
+				t,
+				err.Error(),
+				"conjur.org/conjur-volume-mounts sets file-only for container nginx-1, which requires conjur.org/container-mode=init",
+			)
+		}
+	})
+
+	t.Run("container not receiving volumes", func(t *testing.T) {
+		req, err := addTestAnnotations(req, map[string]string{
+			"conjur.org/conjur-volume-mounts": "secrets-provider-name=/var/secrets",
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		_, err = applyPatchToAdmissionRequest(req)
+		if assert.Error(t, err) {
+			assert.Contains(
+				t,
+				err.Error(),
+				"conjur.org/conjur-volume-mounts refers to container secrets-provider-name, which isn't listed in conjur.org/conjur-inject-volumes",
+			)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package inject
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const conjurAnnotationPrefix = "conjur.org/"
+
+// getNamespaceDefaults returns the `conjur.org/*` annotations declared on a
+// namespace, which act as defaults for the pods created in it. A namespace
+// missing from the cache has no defaults.
+func getNamespaceDefaults(
+	lister corelisters.NamespaceLister,
+	namespace string,
+) map[string]string {
+	if lister == nil || namespace == "" {
+		return nil
+	}
+
+	ns, err := lister.Get(namespace)
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			log.Printf("Unable to look up defaults of namespace %s: %v", namespace, err)
+		}
+		return nil
+	}
+
+	return conjurAnnotations(ns.Annotations)
+}
+
+// conjurAnnotations returns the `conjur.org/*` entries of an annotation map,
+// leaving out the injection status which must never be defaulted
+func conjurAnnotations(annotations map[string]string) map[string]string {
+	result := map[string]string{}
+	for key, value := range annotations {
+		if strings.HasPrefix(key, conjurAnnotationPrefix) && key != annotationStatusKey {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+// mergeAnnotationDefaults sets each of the default annotations that the
+// metadata doesn't already declare, and returns the keys that were set
+func mergeAnnotationDefaults(
+	metadata *metav1.ObjectMeta,
+	defaults map[string]string,
+) []string {
+	var merged []string
+	for key, value := range defaults {
+		if _, ok := metadata.Annotations[key]; ok {
+			continue
+		}
+		if metadata.Annotations == nil {
+			metadata.Annotations = map[string]string{}
+		}
+		metadata.Annotations[key] = value
+		merged = append(merged, key)
+	}
+	sort.Strings(merged)
+
+	return merged
+}
@@ -0,0 +1,78 @@
+package inject
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestNamespaceLister(namespaces ...*corev1.Namespace) corelisters.NamespaceLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range namespaces {
+		_ = indexer.Add(namespace)
+	}
+
+	return corelisters.NewNamespaceLister(indexer)
+}
+
+func TestNamespaceDefaultsInjection(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.NamespaceLister = newTestNamespaceLister(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "dummy",
+			Annotations: map[string]string{
+				"conjur.org/inject":           "true",
+				"conjur.org/inject-type":      "authenticator",
+				"conjur.org/container-mode":   "sidecar",
+				"conjur.org/container-name":   "overridden-by-pod",
+				"conjur.org/conjurAuthConfig": "conjur",
+				"conjur.org/conjurConnConfig": "conjur",
+				"conjur.org/status":           "injected",
+				"other.org/ignored":           "true",
+			},
+		},
+	})
+
+	// The pod only declares its container name and token receivers, everything
+	// else comes from the namespace.
+	req, err := newTestAdmissionRequest(
+		"./testdata/namespace-defaults-annotated-pod.json",
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expectedMod, err := ioutil.ReadFile("./testdata/authenticator-mutated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.JSONEq(t, string(expectedMod), string(mod))
+}
+
+func TestMergeAnnotationDefaults(t *testing.T) {
+	metadata := metav1.ObjectMeta{
+		Annotations: map[string]string{"conjur.org/container-mode": "init"},
+	}
+
+	merged := mergeAnnotationDefaults(&metadata, map[string]string{
+		"conjur.org/container-mode": "sidecar",
+		"conjur.org/inject":         "true",
+	})
+
+	assert.Equal(t, []string{"conjur.org/inject"}, merged)
+	assert.Equal(t, map[string]string{
+		"conjur.org/container-mode": "init",
+		"conjur.org/inject":         "true",
+	}, metadata.Annotations)
+}
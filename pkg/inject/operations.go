@@ -0,0 +1,165 @@
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Subresource used by `kubectl debug` to add ephemeral containers to pods
+const ephemeralContainersSubResource = "ephemeralcontainers"
+
+// Volumes shared by the built-in injected containers with the receiving
+// containers, for pods injected before conjur.org/injected-volumes was set
+var defaultReceiverVolumeNames = []string{
+	"conjur-access-token",
+	"conjur-status",
+	"conjur-secrets",
+}
+
+// injectedVolumeNames returns the names of the volumes added to a pod by its
+// injection, as recorded in its provenance
+func injectedVolumeNames(pod *corev1.Pod) map[string]bool {
+	names := defaultReceiverVolumeNames
+	if value, err := getAnnotation(&pod.ObjectMeta, annotationInjectedVolumesKey); err == nil {
+		names = strings.Split(value, ",")
+	}
+
+	injected := map[string]bool{}
+	for _, name := range names {
+		injected[strings.TrimSpace(name)] = true
+	}
+	return injected
+}
+
+// handleNonCreateRequest handles the admission requests other than the
+// creation of a pod. It returns false for pod creations, which go through the
+// injection.
+func handleNonCreateRequest(
+	sidecarInjectorConfig SidecarInjectorConfig,
+	req *admissionv1.AdmissionRequest,
+) (admissionv1.AdmissionResponse, bool) {
+	// Subresources come first, as those of workloads, e.g. deployments/scale,
+	// don't carry the workload itself
+	switch {
+	case req.SubResource == ephemeralContainersSubResource:
+		return handleEphemeralContainersRequest(sidecarInjectorConfig, req), true
+	case req.SubResource != "":
+		log.Printf("Skipping mutation of subresource %s of %s/%s", req.SubResource, req.Namespace, req.Name)
+		return admissionv1.AdmissionResponse{Allowed: true}, true
+	case isWorkloadResource(req.Resource):
+		return handleWorkloadRequest(sidecarInjectorConfig, req), true
+	case req.Operation != "" && req.Operation != admissionv1.Create:
+		// The containers and volumes of a pod can't change after its creation
+		log.Printf("Skipping mutation of pod %s/%s on %s", req.Namespace, req.Name, req.Operation)
+		return admissionv1.AdmissionResponse{Allowed: true}, true
+	}
+
+	return admissionv1.AdmissionResponse{}, false
+}
+
+// handleEphemeralContainersRequest mounts the volumes shared by the injected
+// containers into the ephemeral containers added to an injected pod, when they
+// target a container receiving these volumes, e.g. with `kubectl debug
+// --target`. Only the ephemeral containers of the pod may be changed.
+func handleEphemeralContainersRequest(
+	sidecarInjectorConfig SidecarInjectorConfig,
+	req *admissionv1.AdmissionRequest,
+) admissionv1.AdmissionResponse {
+	var pod, oldPod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return failWithResponse(
+			fmt.Sprintf("Could not unmarshal raw object: %v", err),
+		)
+	}
+	if len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, &oldPod); err != nil {
+			return failWithResponse(
+				fmt.Sprintf("Could not unmarshal raw old object: %v", err),
+			)
+		}
+	}
+
+	injectedStatus, _ := getAnnotation(&pod.ObjectMeta, annotationStatusKey)
+	if strings.ToLower(injectedStatus) != "injected" {
+		return admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	containerVolumeMounts := ephemeralContainerVolumeMounts(&pod, &oldPod)
+	if len(containerVolumeMounts) == 0 {
+		return admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	mutated := pod.DeepCopy()
+	addEphemeralContainerVolumeMounts(mutated, containerVolumeMounts)
+	patchBytes, err := podPatch(req.Object.Raw, &pod, mutated)
+	if err != nil {
+		return failWithResponse(
+			fmt.Sprintf(
+				"Mutation failed for ephemeral containers of pod %s, in namespace %s, due to %s",
+				pod.Name,
+				req.Namespace,
+				err.Error(),
+			),
+		)
+	}
+
+	log.Printf("AdmissionResponse: patch=%v\n", sidecarInjectorConfig.redactor().patch(patchBytes))
+	return admissionv1.AdmissionResponse{
+		Allowed: true,
+		Patch:   patchBytes,
+		PatchType: func() *admissionv1.PatchType {
+			pt := admissionv1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
+
+// ephemeralContainerVolumeMounts returns the volume mounts of the ephemeral
+// containers added by an update: the injected volumes mounted by the
+// container they target, at the same paths
+func ephemeralContainerVolumeMounts(pod, oldPod *corev1.Pod) ContainerVolumeMounts {
+	injectedVolumes := injectedVolumeNames(pod)
+	existing := map[string]bool{}
+	for _, ephemeralContainer := range oldPod.Spec.EphemeralContainers {
+		existing[ephemeralContainer.Name] = true
+	}
+	targets := map[string]corev1.Container{}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			targets[container.Name] = container
+		}
+	}
+
+	containerVolumeMounts := ContainerVolumeMounts{}
+	for _, ephemeralContainer := range pod.Spec.EphemeralContainers {
+		if existing[ephemeralContainer.Name] {
+			continue
+		}
+		target, ok := targets[ephemeralContainer.TargetContainerName]
+		if !ok {
+			continue
+		}
+
+		mountPaths := map[string]bool{}
+		for _, volumeMount := range ephemeralContainer.VolumeMounts {
+			mountPaths[path.Clean(volumeMount.MountPath)] = true
+		}
+		for _, volumeMount := range target.VolumeMounts {
+			if !injectedVolumes[volumeMount.Name] || mountPaths[path.Clean(volumeMount.MountPath)] {
+				continue
+			}
+			containerVolumeMounts[ephemeralContainer.Name] = append(
+				containerVolumeMounts[ephemeralContainer.Name],
+				volumeMount,
+			)
+		}
+	}
+
+	return containerVolumeMounts
+}
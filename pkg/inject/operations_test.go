@@ -0,0 +1,222 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestInjectedPod(ephemeralContainers ...corev1.EphemeralContainer) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Annotations: map[string]string{"conjur.org/status": "injected"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app"},
+				{
+					Name: "app-with-token",
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "conjur-access-token", MountPath: "/run/conjur"},
+						{Name: "app-data", MountPath: "/data"},
+					},
+				},
+			},
+			EphemeralContainers: ephemeralContainers,
+			Volumes: []corev1.Volume{
+				{Name: "conjur-access-token"},
+				{Name: "app-data"},
+			},
+		},
+	}
+}
+
+func newTestEphemeralContainer(name, target string) corev1.EphemeralContainer {
+	return corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: name},
+		TargetContainerName:      target,
+	}
+}
+
+func TestPodUpdateNotMutated(t *testing.T) {
+	reqJSON, err := newTestAdmissionRequest("./testdata/authenticator-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err := NewAdmissionRequest(reqJSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.Operation = admissionv1.Update
+
+	res := HandleAdmissionRequest(newTestSidecarInjectorConfig(), req)
+	assert.True(t, res.Allowed)
+	assert.Nil(t, res.Patch)
+}
+
+func TestEphemeralContainersUpdate(t *testing.T) {
+	debugger := newTestEphemeralContainer("debugger", "app-with-token")
+
+	var testCases = []struct {
+		description string
+		pod         corev1.Pod
+		oldPod      corev1.Pod
+		expected    map[string][]corev1.VolumeMount
+	}{
+		{
+			description: "target mounting Conjur volumes",
+			pod:         newTestInjectedPod(debugger),
+			oldPod:      newTestInjectedPod(),
+			expected: map[string][]corev1.VolumeMount{
+				"debugger": {{Name: "conjur-access-token", MountPath: "/run/conjur"}},
+			},
+		},
+		{
+			description: "existing ephemeral container",
+			pod: newTestInjectedPod(
+				debugger,
+				newTestEphemeralContainer("debugger-2", "app-with-token"),
+			),
+			oldPod: newTestInjectedPod(debugger),
+			expected: map[string][]corev1.VolumeMount{
+				"debugger":   nil,
+				"debugger-2": {{Name: "conjur-access-token", MountPath: "/run/conjur"}},
+			},
+		},
+		{
+			description: "volumes recorded by the injection",
+			pod: func() corev1.Pod {
+				pod := newTestInjectedPod(debugger)
+				pod.Annotations["conjur.org/injected-volumes"] = "conjur-access-token,app-data"
+				return pod
+			}(),
+			oldPod: newTestInjectedPod(),
+			expected: map[string][]corev1.VolumeMount{
+				"debugger": {
+					{Name: "conjur-access-token", MountPath: "/run/conjur"},
+					{Name: "app-data", MountPath: "/data"},
+				},
+			},
+		},
+		{
+			description: "target without Conjur volumes",
+			pod:         newTestInjectedPod(newTestEphemeralContainer("debugger", "app")),
+			oldPod:      newTestInjectedPod(),
+		},
+		{
+			description: "no target",
+			pod:         newTestInjectedPod(newTestEphemeralContainer("debugger", "")),
+			oldPod:      newTestInjectedPod(),
+		},
+		{
+			description: "pod not injected",
+			pod: func() corev1.Pod {
+				pod := newTestInjectedPod(debugger)
+				pod.Annotations = nil
+				return pod
+			}(),
+			oldPod: newTestInjectedPod(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			podJSON, err := json.Marshal(tc.pod)
+			if !assert.NoError(t, err) {
+				return
+			}
+			oldPodJSON, err := json.Marshal(tc.oldPod)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			res := HandleAdmissionRequest(newTestSidecarInjectorConfig(), &admissionv1.AdmissionRequest{
+				Namespace:   "dummy",
+				Name:        tc.pod.Name,
+				Resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				SubResource: "ephemeralcontainers",
+				Operation:   admissionv1.Update,
+				Object:      runtime.RawExtension{Raw: podJSON},
+				OldObject:   runtime.RawExtension{Raw: oldPodJSON},
+			})
+			assert.True(t, res.Allowed)
+			if tc.expected == nil {
+				assert.Nil(t, res.Patch)
+				return
+			}
+
+			patch, err := jsonpatch.DecodePatch(res.Patch)
+			if !assert.NoError(t, err) {
+				return
+			}
+			mod, err := patch.Apply(podJSON)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var pod corev1.Pod
+			if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+				return
+			}
+			volumeMounts := map[string][]corev1.VolumeMount{}
+			for _, ephemeralContainer := range pod.Spec.EphemeralContainers {
+				volumeMounts[ephemeralContainer.Name] = ephemeralContainer.VolumeMounts
+			}
+			assert.Equal(t, tc.expected, volumeMounts)
+		})
+	}
+}
+
+// TestWorkloadSubresourceNotMutated checks that subresources of workloads are
+// admitted unchanged, even the status of a stale Deployment, which carries the
+// whole Deployment
+func TestWorkloadSubresourceNotMutated(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.UpgradeWorkloads = true
+
+	deployment := newTestDeployment("dummy", "nginx", map[string]string{
+		"conjur.org/inject":              "yes",
+		"conjur.org/inject-type":         "authenticator",
+		"conjur.org/sidecar-images-hash": "0123456789abcdef",
+	})
+	deploymentJSON, err := json.Marshal(deployment)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var testCases = []struct {
+		subResource string
+		raw         []byte
+	}{
+		{
+			subResource: "status",
+			raw:         deploymentJSON,
+		},
+		{
+			subResource: "scale",
+			raw:         []byte(`{"apiVersion": "autoscaling/v1", "kind": "Scale", "metadata": {"name": "nginx"}, "spec": {"replicas": 3}}`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.subResource, func(t *testing.T) {
+			res := HandleAdmissionRequest(sidecarInjectorConfig, &admissionv1.AdmissionRequest{
+				Namespace:   "dummy",
+				Name:        "nginx",
+				Resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+				SubResource: tc.subResource,
+				Operation:   admissionv1.Update,
+				Object:      runtime.RawExtension{Raw: tc.raw},
+			})
+			assert.True(t, res.Allowed)
+			assert.Nil(t, res.Result)
+			assert.Nil(t, res.Patch)
+		})
+	}
+}
@@ -1,15 +1,20 @@
 package inject
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 )
 
 // RFC6902 JSON patches
 type rfc6902PatchOperation struct {
 	Op    string      `json:"op"`
+	From  string      `json:"from,omitempty"`
 	Path  string      `json:"path"`
 	Value interface{} `json:"value,omitempty"`
 }
@@ -18,178 +23,221 @@ type rfc6902PatchOperation struct {
 const (
 	patchOperationAdd     = "add"
 	patchOperationReplace = "replace"
+	patchOperationRemove  = "remove"
+	patchOperationMove    = "move"
 )
 
-// create mutation patch for resources
+// createPatch creates the mutation patch of the pod of an admission request,
+// from the pod with the PatchConfig applied and the given annotations
 func createPatch(
-	pod *corev1.Pod,
+	original []byte,
 	sidecarConfig *PatchConfig,
 	annotations map[string]string,
 ) ([]byte, error) {
-	var patch []rfc6902PatchOperation
-
-	patch = append(
-		patch,
-		addContainer(
-			pod.Spec.InitContainers,
-			sidecarConfig.InitContainers,
-			"/spec/initContainers",
-		)...,
-	)
-	patch = append(
-		patch,
-		addContainer(
-			pod.Spec.Containers,
-			sidecarConfig.Containers,
-			"/spec/containers",
-		)...,
-	)
-	patch = append(
-		patch,
-		addVolume(
-			pod.Spec.Volumes,
-			sidecarConfig.Volumes, "/spec/volumes",
-		)...,
-	)
-	patch = append(
-		patch,
-		updateAnnotation(
-			pod.Annotations,
-			annotations,
-		)...,
-	)
-	patch = append(
-		patch,
-		addVolumeMounts(
-			pod.Spec.Containers,
-			sidecarConfig.ContainerVolumeMounts,
-			"/spec/containers",
-		)...,
-	)
+	var pod corev1.Pod
+	if err := json.Unmarshal(original, &pod); err != nil {
+		return nil, err
+	}
 
-	return json.Marshal(patch)
+	mutated := pod.DeepCopy()
+	applyPatchConfig(mutated, sidecarConfig)
+	if len(annotations) > 0 || pod.Annotations != nil {
+		mutated.Annotations = annotations
+	}
+
+	return podPatch(original, &pod, mutated)
 }
 
-// addContainer create a patch for adding containers
-func addContainer(
-	target, added []corev1.Container,
-	basePath string,
-) (patch []rfc6902PatchOperation) {
-	first := len(target) == 0
-	var value interface{}
+// podPatch returns the JSON patch turning a pod into its mutated version, once
+// checked that applying it to the pod of the admission request results in the
+// mutated pod, and that this pod is valid
+func podPatch(original []byte, pod, mutated *corev1.Pod) ([]byte, error) {
+	from, err := toJSONValue(pod)
+	if err != nil {
+		return nil, err
+	}
+	to, err := toJSONValue(mutated)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, add := range added {
-		value = add
-		path := basePath
-		if first {
-			first = false
-			value = []corev1.Container{add}
-		} else {
-			path = path + "/-"
-		}
-		patch = append(patch, rfc6902PatchOperation{
-			Op:    patchOperationAdd,
-			Path:  path,
-			Value: value,
-		})
+	patch := append([]rfc6902PatchOperation{}, diffJSON("", from, to)...)
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
 	}
 
-	return patch
+	if err := validatePatch(original, patchBytes, mutated); err != nil {
+		return nil, err
+	}
+
+	return patchBytes, nil
 }
 
-// addVolumeMounts creates a patch for adding volume mounts
-func addVolumeMounts(
-	target []corev1.Container,
-	added ContainerVolumeMounts,
-	basePath string,
-) (patch []rfc6902PatchOperation) {
-	for index, container := range target {
-		volumeMounts, ok := added[container.Name]
-		if !ok || len(volumeMounts) == 0 {
-			continue
-		}
+// validatePatch applies a patch to the pod of the admission request and
+// validates the result, which must be the expected mutated pod
+func validatePatch(original, patchBytes []byte, mutated *corev1.Pod) error {
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return err
+	}
+	patched, err := patch.Apply(original)
+	if err != nil {
+		return fmt.Errorf("unable to apply patch: %v", err)
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(patched, &pod); err != nil {
+		return err
+	}
+	if !equality.Semantic.DeepEqual(&pod, mutated) {
+		return fmt.Errorf("patch doesn't result in the mutated pod")
+	}
+
+	if err := validatePod(&pod); err != nil {
+		return fmt.Errorf("invalid mutated pod: %v", err)
+	}
 
-		if len(container.VolumeMounts) == 0 {
-			volumeMount := volumeMounts[0]
-			volumeMounts = volumeMounts[1:]
+	return nil
+}
 
-			path := fmt.Sprintf("%s/%d/volumeMounts", basePath, index)
-			patch = append(patch, rfc6902PatchOperation{
-				Op:    patchOperationAdd,
-				Path:  path,
-				Value: []corev1.VolumeMount{volumeMount},
-			})
+// applyPatchConfig adds the containers, volumes, volume mounts, liveness
+// probes and environment variables of a PatchConfig to a pod. The mounts,
+// probes and environment variables only apply to the containers of the pod,
+// not the added ones.
+func applyPatchConfig(pod *corev1.Pod, sidecarConfig *PatchConfig) {
+	for i := range pod.Spec.InitContainers {
+		container := &pod.Spec.InitContainers[i]
+		container.VolumeMounts = append(
+			container.VolumeMounts,
+			sidecarConfig.ContainerVolumeMounts[container.Name]...,
+		)
+	}
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		container.VolumeMounts = append(
+			container.VolumeMounts,
+			sidecarConfig.ContainerVolumeMounts[container.Name]...,
+		)
+		if probe, ok := sidecarConfig.ContainerLivenessProbes[container.Name]; ok {
+			container.LivenessProbe = probe
 		}
+		container.Env = append(container.Env, sidecarConfig.ContainerEnv[container.Name]...)
+	}
+	addEphemeralContainerVolumeMounts(pod, sidecarConfig.ContainerVolumeMounts)
+
+	pod.Spec.InitContainers = insertInitContainers(
+		pod.Spec.InitContainers,
+		sidecarConfig.InitContainers,
+		sidecarConfig.ContainerVolumeMounts,
+	)
+	pod.Spec.Containers = moveContainersFirst(
+		append(pod.Spec.Containers, sidecarConfig.Containers...),
+		sidecarConfig.StartFirst,
+	)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, sidecarConfig.Volumes...)
+}
+
+// insertInitContainers inserts the injected init containers before the first
+// init container of the pod receiving their volumes, so that the volumes are
+// filled when it runs, or after the init containers of the pod otherwise
+func insertInitContainers(
+	initContainers []corev1.Container,
+	injected []corev1.Container,
+	containerVolumeMounts ContainerVolumeMounts,
+) []corev1.Container {
+	if len(injected) == 0 {
+		return initContainers
+	}
 
-		path := fmt.Sprintf("%s/%d/volumeMounts/-", basePath, index)
-		for _, volumeMount := range volumeMounts {
-			patch = append(patch, rfc6902PatchOperation{
-				Op:    patchOperationAdd,
-				Path:  path,
-				Value: volumeMount,
-			})
+	for i, container := range initContainers {
+		if len(containerVolumeMounts[container.Name]) > 0 {
+			var result []corev1.Container
+			result = append(result, initContainers[:i]...)
+			result = append(result, injected...)
+			return append(result, initContainers[i:]...)
 		}
 	}
 
-	return patch
+	return append(initContainers, injected...)
 }
 
-// addVolume creates a patch for adding volumes
-func addVolume(
-	target, added []corev1.Volume,
-	basePath string,
-) (patch []rfc6902PatchOperation) {
-	first := len(target) == 0
-	var value interface{}
+// addEphemeralContainerVolumeMounts adds volume mounts to the ephemeral
+// containers of a pod
+func addEphemeralContainerVolumeMounts(pod *corev1.Pod, containerVolumeMounts ContainerVolumeMounts) {
+	for i := range pod.Spec.EphemeralContainers {
+		container := &pod.Spec.EphemeralContainers[i]
+		container.VolumeMounts = append(
+			container.VolumeMounts,
+			containerVolumeMounts[container.Name]...,
+		)
+	}
+}
 
-	for _, add := range added {
-		value = add
-		path := basePath
+// moveContainersFirst moves the named containers, in order, in front of the
+// other containers
+func moveContainersFirst(containers []corev1.Container, names []string) []corev1.Container {
+	if len(names) == 0 {
+		return containers
+	}
 
-		if first {
-			first = false
-			value = []corev1.Volume{add}
-		} else {
-			path = path + "/-"
+	first := map[string]bool{}
+	var result []corev1.Container
+	for _, name := range names {
+		for _, container := range containers {
+			if container.Name == name && !first[name] {
+				first[name] = true
+				result = append(result, container)
+			}
+		}
+	}
+	for _, container := range containers {
+		if !first[container.Name] {
+			result = append(result, container)
 		}
-
-		patch = append(patch, rfc6902PatchOperation{
-			Op:    patchOperationAdd,
-			Path:  path,
-			Value: value,
-		})
 	}
 
-	return patch
+	return result
 }
 
-// updateAnnotation creates a patch for adding/updating annotations
-func updateAnnotation(
-	target, added map[string]string,
-) (patch []rfc6902PatchOperation) {
+// toJSONValue returns the generic JSON value of an object
+func toJSONValue(object interface{}) (interface{}, error) {
+	objectJSON, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
 
-	for key, value := range added {
-		target[key] = value
-	}
-	// Remove annotations that are only for the sidecar injector
-	for _, value := range sidecarInjectorAnnot {
-		delete(target, value)
-    }
-	path := "/metadata/annotations"
-
-	patch = append(patch, rfc6902PatchOperation{
-		Op:    patchOperationAdd,
-		Path:  path,
-		Value: target,
-	})
-	return patch
+	var value interface{}
+	err = json.Unmarshal(objectJSON, &value)
+	return value, err
 }
 
-func printPrettyPatch(patch []byte) string {
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, patch, "", "\t"); err != nil {
-		// Could not print pretty, so print ugly
-		return string(patch)
+// injectedAnnotations returns the annotations of an injected pod: its own
+// annotations with the added ones, without the annotations only used by the
+// sidecar injector unless they are kept. The pod annotations aren't changed.
+func injectedAnnotations(
+	annotations, added map[string]string,
+	injectTypes []string,
+	keepInjectorAnnotations bool,
+) map[string]string {
+	result := maps.Clone(annotations)
+	if result == nil {
+		result = map[string]string{}
+	}
+	if !keepInjectorAnnotations {
+		for _, key := range sidecarInjectorAnnot {
+			delete(result, key)
+		}
+		stripTypeAnnotations(result, injectTypes)
+	}
+	for key, value := range added {
+		result[key] = value
 	}
-	return string(prettyJSON.Bytes())
+
+	return result
+}
+
+// escapeJSONPointer escapes a key for use in a JSON pointer, as per RFC6901
+func escapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
 }
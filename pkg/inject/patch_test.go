@@ -0,0 +1,235 @@
+package inject
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestDiffJSON(t *testing.T) {
+	container := func(name string, fields ...string) map[string]interface{} {
+		result := map[string]interface{}{"name": name}
+		for i := 0; i < len(fields); i += 2 {
+			result[fields[i]] = fields[i+1]
+		}
+		return result
+	}
+
+	var testCases = []struct {
+		description string
+		from        interface{}
+		to          interface{}
+		expected    []rfc6902PatchOperation
+	}{
+		{
+			description: "annotations added",
+			from:        map[string]interface{}{"metadata": map[string]interface{}{}},
+			to: map[string]interface{}{"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{"conjur.org/status": "injected"},
+			}},
+			expected: []rfc6902PatchOperation{
+				{
+					Op:    "add",
+					Path:  "/metadata/annotations",
+					Value: map[string]interface{}{"conjur.org/status": "injected"},
+				},
+			},
+		},
+		{
+			description: "keys added, replaced and removed",
+			from: map[string]interface{}{
+				"conjur.org/inject":       "true",
+				"conjur.org/injected-at":  "2020-01-01T00:00:00Z",
+				"other.org/unchanged":     "true",
+				"example.com/a~b":         "",
+				"conjur.org/inject-types": "secretless",
+			},
+			to: map[string]interface{}{
+				"conjur.org/injected-at":  "2021-01-01T00:00:00Z",
+				"conjur.org/status":       "injected",
+				"other.org/unchanged":     "true",
+				"conjur.org/inject-types": "secretless",
+			},
+			expected: []rfc6902PatchOperation{
+				{Op: "remove", Path: "/conjur.org~1inject"},
+				{Op: "replace", Path: "/conjur.org~1injected-at", Value: "2021-01-01T00:00:00Z"},
+				{Op: "add", Path: "/conjur.org~1status", Value: "injected"},
+				{Op: "remove", Path: "/example.com~1a~0b"},
+			},
+		},
+		{
+			description: "named elements inserted, moved, changed and removed",
+			from: []interface{}{
+				container("app"),
+				container("worker", "image", "worker:1"),
+				container("debug"),
+			},
+			to: []interface{}{
+				container("sidecar"),
+				container("worker", "image", "worker:2"),
+				container("app"),
+				container("logger"),
+			},
+			expected: []rfc6902PatchOperation{
+				{Op: "remove", Path: "/2"},
+				{Op: "add", Path: "/0", Value: container("sidecar")},
+				{Op: "move", From: "/2", Path: "/1"},
+				{Op: "replace", Path: "/1/image", Value: "worker:2"},
+				{Op: "add", Path: "/-", Value: container("logger")},
+			},
+		},
+		{
+			description: "elements without names",
+			from:        []interface{}{"sh", "-c", "sleep 1"},
+			to:          []interface{}{"sh", "-c", "sleep 2", "--verbose"},
+			expected: []rfc6902PatchOperation{
+				{Op: "replace", Path: "/2", Value: "sleep 2"},
+				{Op: "add", Path: "/-", Value: "--verbose"},
+			},
+		},
+		{
+			description: "unchanged",
+			from:        map[string]interface{}{"conjur.org/status": "injected"},
+			to:          map[string]interface{}{"conjur.org/status": "injected"},
+			expected:    nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, diffJSON("", tc.from, tc.to))
+		})
+	}
+}
+
+func TestInvalidMutation(t *testing.T) {
+	var testCases = []struct {
+		description   string
+		sidecarConfig *PatchConfig
+		errContains   string
+	}{
+		{
+			description: "duplicate container name",
+			sidecarConfig: &PatchConfig{
+				Containers: []corev1.Container{{Name: "nginx-1", Image: "sidecar"}},
+			},
+			errContains: `spec.containers[2].name: Duplicate value: "nginx-1"`,
+		},
+		{
+			description: "mount of a missing volume",
+			sidecarConfig: &PatchConfig{
+				Containers: []corev1.Container{{
+					Name:         "sidecar",
+					VolumeMounts: []corev1.VolumeMount{{Name: "missing", MountPath: "/missing"}},
+				}},
+			},
+			errContains: `spec.containers[2].volumeMounts[0].name: Not found: "missing"`,
+		},
+		{
+			description: "invalid container name",
+			sidecarConfig: &PatchConfig{
+				Containers: []corev1.Container{{Name: "Sidecar_1"}},
+			},
+			errContains: `spec.containers[2].name: Invalid value: "Sidecar_1"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			pod, err := ioutil.ReadFile("./testdata/authenticator-annotated-pod.json")
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			_, err = createPatch(pod, tc.sidecarConfig, nil)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "invalid mutated pod")
+				assert.Contains(t, err.Error(), tc.errContains)
+			}
+		})
+	}
+}
+
+func TestInjectedAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		"conjur.org/inject":                          "true",
+		"conjur.org/inject-type":                     "secrets-provider",
+		"conjur.org/secrets-provider.container-name": "provider",
+		"conjur.org/container-mode":                  "init",
+		"other.org/label":                            "value",
+	}
+	added := map[string]string{"conjur.org/status": "injected"}
+
+	assert.Equal(t, map[string]string{
+		"conjur.org/container-mode": "init",
+		"conjur.org/status":         "injected",
+		"other.org/label":           "value",
+	}, injectedAnnotations(annotations, added, []string{"secrets-provider"}, false))
+
+	kept := injectedAnnotations(annotations, added, []string{"secrets-provider"}, true)
+	assert.Len(t, kept, 6)
+	assert.Equal(t, "true", kept["conjur.org/inject"])
+	assert.Equal(t, "provider", kept["conjur.org/secrets-provider.container-name"])
+
+	// The pod annotations are left unchanged
+	assert.Len(t, annotations, 5)
+	assert.NotContains(t, annotations, "conjur.org/status")
+}
+
+func TestAnnotationPatchKeepsConcurrentChanges(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		sidecarInjectorConfig := newTestSidecarInjectorConfig()
+		sidecarInjectorConfig.KeepInjectorAnnotations = keep
+
+		reqJSON, err := newTestAdmissionRequest("./testdata/authenticator-annotated-pod.json")
+		if !assert.NoError(t, err) {
+			return
+		}
+		req, err := NewAdmissionRequest(reqJSON)
+		if !assert.NoError(t, err) {
+			return
+		}
+		podJSON := string(req.Object.Raw)
+
+		res := HandleAdmissionRequest(sidecarInjectorConfig, req)
+		if !assert.Nil(t, res.Result) {
+			return
+		}
+		assert.Equal(t, podJSON, string(req.Object.Raw))
+
+		// Another mutating webhook annotates the pod before the patch is applied
+		var pod corev1.Pod
+		if !assert.NoError(t, json.Unmarshal(req.Object.Raw, &pod)) {
+			return
+		}
+		pod.Annotations["other.org/added-by-webhook"] = "true"
+		podBytes, err := json.Marshal(pod)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		patch, err := jsonpatch.DecodePatch(res.Patch)
+		if !assert.NoError(t, err) {
+			return
+		}
+		mod, err := patch.Apply(podBytes)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var mutated corev1.Pod
+		if !assert.NoError(t, json.Unmarshal(mod, &mutated)) {
+			return
+		}
+		assert.Equal(t, "true", mutated.Annotations["other.org/added-by-webhook"])
+		assert.Equal(t, "injected", mutated.Annotations["conjur.org/status"])
+		if keep {
+			assert.Equal(t, "authenticator", mutated.Annotations["conjur.org/inject-type"])
+		} else {
+			assert.NotContains(t, mutated.Annotations, "conjur.org/inject-type")
+		}
+	}
+}
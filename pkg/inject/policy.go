@@ -0,0 +1,188 @@
+package inject
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SidecarInjectionPolicyResource identifies the SidecarInjectionPolicy custom
+// resource
+var SidecarInjectionPolicyResource = schema.GroupVersionResource{
+	Group:    "conjur.org",
+	Version:  "v1alpha1",
+	Resource: "sidecarinjectionpolicies",
+}
+
+// SidecarInjectionPolicy requests sidecar injection for the pods of its
+// namespace that match its selector, without requiring pod annotations
+type SidecarInjectionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SidecarInjectionPolicySpec   `json:"spec"`
+	Status SidecarInjectionPolicyStatus `json:"status,omitempty"`
+}
+
+// SidecarInjectionPolicySpec mirrors the `conjur.org/*` pod annotations
+type SidecarInjectionPolicySpec struct {
+	Selector            metav1.LabelSelector `json:"selector"`
+	InjectType          string               `json:"injectType"`
+	ContainerMode       string               `json:"containerMode,omitempty"`
+	ContainerName       string               `json:"containerName,omitempty"`
+	ContainerImage      string               `json:"containerImage,omitempty"`
+	InjectVolumes       []string             `json:"injectVolumes,omitempty"`
+	SecretlessConfig    string               `json:"secretlessConfig,omitempty"`
+	SecretlessCRDSuffix string               `json:"secretlessCRDSuffix,omitempty"`
+	ConjurAuthConfig    string               `json:"conjurAuthConfig,omitempty"`
+	ConjurConnConfig    string               `json:"conjurConnConfig,omitempty"`
+	SecretsDestination  string               `json:"secretsDestination,omitempty"`
+	// Any other `conjur.org/*` annotations, e.g. those read by the Secrets Provider
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SidecarInjectionPolicyStatus reports the pods matched by a policy
+type SidecarInjectionPolicyStatus struct {
+	MatchedPods     int64        `json:"matchedPods,omitempty"`
+	LastMatchedPod  string       `json:"lastMatchedPod,omitempty"`
+	LastMatchedTime *metav1.Time `json:"lastMatchedTime,omitempty"`
+}
+
+// SidecarInjectionPolicyLister lists the cached policies of a namespace
+type SidecarInjectionPolicyLister interface {
+	List(namespace string) ([]*SidecarInjectionPolicy, error)
+}
+
+// dynamicPolicyLister converts the objects cached by a dynamic informer
+type dynamicPolicyLister struct {
+	lister cache.GenericLister
+}
+
+// NewSidecarInjectionPolicyLister returns a SidecarInjectionPolicyLister
+// backed by the lister of a dynamic informer
+func NewSidecarInjectionPolicyLister(lister cache.GenericLister) SidecarInjectionPolicyLister {
+	return &dynamicPolicyLister{lister: lister}
+}
+
+func (l *dynamicPolicyLister) List(namespace string) ([]*SidecarInjectionPolicy, error) {
+	objects, err := l.lister.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []*SidecarInjectionPolicy
+	for _, object := range objects {
+		u, ok := object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		policy := &SidecarInjectionPolicy{}
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, policy)
+		if err != nil {
+			log.Printf("Ignoring invalid SidecarInjectionPolicy %s/%s: %v", namespace, u.GetName(), err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// toAnnotations returns the pod annotations equivalent to the policy spec
+func (spec SidecarInjectionPolicySpec) toAnnotations() map[string]string {
+	annotations := map[string]string{}
+	for key, value := range spec.Annotations {
+		if strings.HasPrefix(key, conjurAnnotationPrefix) && key != annotationStatusKey {
+			annotations[key] = value
+		}
+	}
+
+	annotations[annotationInjectKey] = "true"
+	for key, value := range map[string]string{
+		annotationInjectTypeKey:          spec.InjectType,
+		annotationContainerModeKey:       spec.ContainerMode,
+		annotationContainerNameKey:       spec.ContainerName,
+		annotationContainerImageKey:      spec.ContainerImage,
+		annotationConjurInjectVolumesKey: strings.Join(spec.InjectVolumes, ","),
+		annotationSecretlessConfigKey:    spec.SecretlessConfig,
+		annotationSecretlessCRDSuffixKey: spec.SecretlessCRDSuffix,
+		annotationConjurAuthConfigKey:    spec.ConjurAuthConfig,
+		annotationConjurConnConfigKey:    spec.ConjurConnConfig,
+		annotationSecretsDestinationKey:  spec.SecretsDestination,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+
+	return annotations
+}
+
+// matchingPolicy returns the policy whose selector matches the pod. When
+// several policies match, the oldest one wins, with ties broken by name.
+func matchingPolicy(
+	lister SidecarInjectionPolicyLister,
+	namespace string,
+	pod *corev1.Pod,
+) (*SidecarInjectionPolicy, error) {
+	if lister == nil {
+		return nil, nil
+	}
+
+	policies, err := lister.List(namespace)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		ti, tj := policies[i].CreationTimestamp, policies[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return policies[i].Name < policies[j].Name
+	})
+
+	podLabels := labels.Set(pod.Labels)
+	for _, policy := range policies {
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.Selector)
+		if err != nil {
+			log.Printf("Ignoring SidecarInjectionPolicy %s/%s with invalid selector: %v", namespace, policy.Name, err)
+			continue
+		}
+		if selector.Matches(podLabels) {
+			return policy, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// recordPolicyMatch queues the status update of a policy after it was used to
+// inject a pod. The update runs in the background and doesn't affect the
+// admission.
+func recordPolicyMatch(
+	updater *PolicyStatusUpdater,
+	policy *SidecarInjectionPolicy,
+	podName string,
+	effects sideEffects,
+) {
+	if updater == nil {
+		return
+	}
+
+	effects.write(
+		fmt.Sprintf("status update of SidecarInjectionPolicy %s/%s", policy.Namespace, policy.Name),
+		func() error {
+			updater.Record(policy, podName)
+			return nil
+		},
+	)
+}
@@ -0,0 +1,158 @@
+package inject
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// policyStatusTimeout bounds the status update of a policy
+const policyStatusTimeout = 5 * time.Second
+
+// policyMatches are the admissions matched by a policy since its last status
+// update
+type policyMatches struct {
+	count    int64
+	lastPod  string
+	lastTime time.Time
+}
+
+// PolicyStatusUpdater updates the status of SidecarInjectionPolicies in the
+// background, off the admission path. The admissions matched by a policy
+// between two updates are coalesced into one status update. The status counts
+// admissions, not pods: a pod admitted again after being denied by a later
+// webhook, or retried by the API server, is counted again.
+type PolicyStatusUpdater struct {
+	Client dynamic.Interface
+
+	queue   workqueue.TypedRateLimitingInterface[string]
+	mutex   sync.Mutex
+	pending map[string]*policyMatches // by policy key, namespace/name
+}
+
+// NewPolicyStatusUpdater returns a PolicyStatusUpdater, which records matches
+// until it runs
+func NewPolicyStatusUpdater(client dynamic.Interface) *PolicyStatusUpdater {
+	return &PolicyStatusUpdater{
+		Client: client,
+		queue: workqueue.NewTypedRateLimitingQueue(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+		),
+		pending: map[string]*policyMatches{},
+	}
+}
+
+// Record queues the status update of a policy matched by an admission,
+// without waiting for it
+func (u *PolicyStatusUpdater) Record(policy *SidecarInjectionPolicy, podName string) {
+	key, err := cache.MetaNamespaceKeyFunc(policy)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	u.mutex.Lock()
+	matches, ok := u.pending[key]
+	if !ok {
+		matches = &policyMatches{}
+		u.pending[key] = matches
+	}
+	matches.count++
+	matches.lastPod = podName
+	matches.lastTime = time.Now().UTC()
+	u.mutex.Unlock()
+
+	u.queue.Add(key)
+}
+
+// Run updates the status of the matched policies until the context is
+// cancelled
+func (u *PolicyStatusUpdater) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	go func() {
+		<-ctx.Done()
+		u.queue.ShutDown()
+	}()
+
+	for u.processNextItem(ctx) {
+	}
+}
+
+func (u *PolicyStatusUpdater) processNextItem(ctx context.Context) bool {
+	key, shutdown := u.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer u.queue.Done(key)
+
+	if err := u.update(ctx, key); err != nil {
+		log.Printf("Unable to update status of SidecarInjectionPolicy %s: %v", key, err)
+		u.queue.AddRateLimited(key)
+		return true
+	}
+	u.queue.Forget(key)
+	return true
+}
+
+// update adds the pending matches of a policy to its status. Matches that
+// couldn't be written are kept for the next attempt.
+func (u *PolicyStatusUpdater) update(ctx context.Context, key string) error {
+	u.mutex.Lock()
+	matches, ok := u.pending[key]
+	delete(u.pending, key)
+	u.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, policyStatusTimeout)
+	defer cancel()
+
+	policies := u.Client.Resource(SidecarInjectionPolicyResource).Namespace(namespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		policy, err := policies.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		matched, _, _ := unstructured.NestedInt64(policy.Object, "status", "matchedPods")
+		status := map[string]interface{}{
+			"matchedPods":     matched + matches.count,
+			"lastMatchedPod":  matches.lastPod,
+			"lastMatchedTime": matches.lastTime.Format(time.RFC3339),
+		}
+		if err := unstructured.SetNestedMap(policy.Object, status, "status"); err != nil {
+			return err
+		}
+
+		_, err = policies.UpdateStatus(ctx, policy, metav1.UpdateOptions{})
+		return err
+	})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		u.mutex.Lock()
+		if newer, ok := u.pending[key]; ok {
+			newer.count += matches.count
+		} else {
+			u.pending[key] = matches
+		}
+		u.mutex.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package inject
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// staticPolicyLister lists a fixed set of policies
+type staticPolicyLister []*SidecarInjectionPolicy
+
+func (l staticPolicyLister) List(namespace string) ([]*SidecarInjectionPolicy, error) {
+	var policies []*SidecarInjectionPolicy
+	for _, policy := range l {
+		if policy.Namespace == namespace {
+			policies = append(policies, policy)
+		}
+	}
+	return policies, nil
+}
+
+func newTestPolicy(name string, created time.Time, matchLabels map[string]string) *SidecarInjectionPolicy {
+	return &SidecarInjectionPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "dummy",
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: SidecarInjectionPolicySpec{
+			Selector:         metav1.LabelSelector{MatchLabels: matchLabels},
+			InjectType:       "authenticator",
+			ContainerMode:    "sidecar",
+			ContainerName:    "authenticator-name",
+			InjectVolumes:    []string{"nginx-2"},
+			ConjurAuthConfig: "conjur",
+			ConjurConnConfig: "conjur",
+		},
+	}
+}
+
+// newTestPolicyClient returns a fake client serving a policy
+func newTestPolicyClient(policy *SidecarInjectionPolicy) (*dynamicfake.FakeDynamicClient, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	if err != nil {
+		return nil, err
+	}
+	unstructuredPolicy := &unstructured.Unstructured{Object: u}
+	unstructuredPolicy.SetAPIVersion("conjur.org/v1alpha1")
+	unstructuredPolicy.SetKind("SidecarInjectionPolicy")
+	return dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), unstructuredPolicy), nil
+}
+
+// testMatchedPods returns the matchedPods status of a policy
+func testMatchedPods(client *dynamicfake.FakeDynamicClient, name string) int64 {
+	updated, err := client.Resource(SidecarInjectionPolicyResource).
+		Namespace("dummy").
+		Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return -1
+	}
+	matched, _, _ := unstructured.NestedInt64(updated.Object, "status", "matchedPods")
+	return matched
+}
+
+func TestPolicyInjection(t *testing.T) {
+	policy := newTestPolicy("nginx", time.Now(), map[string]string{"app": "nginx"})
+	dynamicClient, err := newTestPolicyClient(policy)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updater := NewPolicyStatusUpdater(dynamicClient)
+	go updater.Run(ctx)
+
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.PolicyLister = staticPolicyLister{policy}
+	sidecarInjectorConfig.PolicyStatus = updater
+
+	req, err := newTestAdmissionRequest("./testdata/policy-matched-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	expectedMod, err := ioutil.ReadFile("./testdata/authenticator-mutated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.JSONEq(t, string(expectedMod), string(mod))
+
+	assert.Eventually(t, func() bool {
+		return testMatchedPods(dynamicClient, "nginx") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPolicyStatusUpdater(t *testing.T) {
+	policy := newTestPolicy("nginx", time.Now(), map[string]string{"app": "nginx"})
+	dynamicClient, err := newTestPolicyClient(policy)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Matches recorded before an update are coalesced into it
+	updater := NewPolicyStatusUpdater(dynamicClient)
+	for _, podName := range []string{"nginx-1", "nginx-2", "nginx-3"} {
+		updater.Record(policy, podName)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go updater.Run(ctx)
+
+	assert.Eventually(t, func() bool {
+		return testMatchedPods(dynamicClient, "nginx") == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	var updates int
+	for _, action := range dynamicClient.Actions() {
+		if action.GetVerb() == "update" && action.GetSubresource() == "status" {
+			updates++
+		}
+	}
+	assert.Equal(t, 1, updates)
+
+	updated, err := dynamicClient.Resource(SidecarInjectionPolicyResource).
+		Namespace("dummy").
+		Get(context.Background(), "nginx", metav1.GetOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	lastPod, _, _ := unstructured.NestedString(updated.Object, "status", "lastMatchedPod")
+	assert.Equal(t, "nginx-3", lastPod)
+}
+
+func TestMatchingPolicy(t *testing.T) {
+	now := time.Now()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "nginx"}},
+	}
+
+	var testCases = []struct {
+		description string
+		policies    staticPolicyLister
+		expected    string
+	}{
+		{
+			description: "no matching policy",
+			policies: staticPolicyLister{
+				newTestPolicy("other", now, map[string]string{"app": "other"}),
+			},
+			expected: "",
+		},
+		{
+			description: "empty selector matches every pod",
+			policies: staticPolicyLister{
+				newTestPolicy("all", now, nil),
+			},
+			expected: "all",
+		},
+		{
+			description: "oldest matching policy wins",
+			policies: staticPolicyLister{
+				newTestPolicy("newer", now, map[string]string{"app": "nginx"}),
+				newTestPolicy("older", now.Add(-time.Hour), nil),
+			},
+			expected: "older",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			policy, err := matchingPolicy(tc.policies, "dummy", pod)
+			assert.NoError(t, err)
+
+			name := ""
+			if policy != nil {
+				name = policy.Name
+			}
+			assert.Equal(t, tc.expected, name)
+		})
+	}
+}
+
+func TestPodAnnotationsOverridePolicy(t *testing.T) {
+	metadata := metav1.ObjectMeta{
+		Annotations: map[string]string{annotationContainerModeKey: "init"},
+	}
+	policy := newTestPolicy("nginx", time.Now(), nil)
+
+	mergeAnnotationDefaults(&metadata, policy.Spec.toAnnotations())
+
+	assert.Equal(t, "init", metadata.Annotations[annotationContainerModeKey])
+	assert.Equal(t, "true", metadata.Annotations[annotationInjectKey])
+	assert.Equal(t, "nginx-2", metadata.Annotations[annotationConjurInjectVolumesKey])
+}
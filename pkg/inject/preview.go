@@ -0,0 +1,455 @@
+package inject
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Volume of the service account token mounted in previewed pods that don't
+// mount it, as the ServiceAccount admission controller does for created pods
+const previewServiceAccountVolume = "kube-api-access-preview"
+
+// Largest manifest accepted by the preview endpoint, as for admission requests
+const maxPreviewBodySize = 3 * 1024 * 1024
+
+// Path of the pod template in the manifests of the workload kinds that can be
+// previewed, besides pods
+var podTemplatePaths = map[string][]string{
+	"Deployment":  {"spec", "template"},
+	"StatefulSet": {"spec", "template"},
+	"DaemonSet":   {"spec", "template"},
+	"ReplicaSet":  {"spec", "template"},
+	"Job":         {"spec", "template"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template"},
+}
+
+// PreviewResponse is the result of an injection preview
+type PreviewResponse struct {
+	// Manifest with the mutation applied
+	Object interface{} `json:"object"`
+	// JSON patch applied to the manifest
+	Patch []rfc6902PatchOperation `json:"patch"`
+	// Warnings returned to the client creating the manifest
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Preview is the handler of the preview endpoint, which runs a Pod or workload
+// manifest, in JSON or YAML, through the sidecar injector logic with the live
+// configuration and returns the mutated manifest, the patch and the warnings.
+// Requests are dry runs, so nothing is created for the previewed pods. They
+// must carry the bearer token of a user allowed to create pods in the
+// namespace of the preview, as the preview reveals the injector configuration.
+func (whsvr *WebhookServer) Preview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if whsvr.KubeClient == nil {
+		http.Error(w, "previews require the Kubernetes API to authorize requests", http.StatusInternalServerError)
+		return
+	}
+	user, code, err := authenticatePreview(r.Context(), whsvr.KubeClient, r)
+	if err != nil {
+		log.Printf("Preview unauthenticated: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPreviewBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not read body: %v", err), http.StatusBadRequest)
+		return
+	}
+	_, object, err := parsePreviewManifest(body)
+	if err != nil {
+		log.Printf("Preview failed: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	namespace := previewNamespace(object, r.URL.Query().Get("namespace"))
+	if code, err := authorizePreview(r.Context(), whsvr.KubeClient, user, namespace); err != nil {
+		log.Printf("Preview unauthorized for %s: %v", user.Username, err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	response, err := previewInjection(whsvr.InjectorConfig(), body, namespace)
+	if err != nil {
+		log.Printf("Preview failed: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(response); err != nil {
+		log.Printf("could not write preview response: %v", err)
+	}
+}
+
+// authenticatePreview returns the user of the bearer token of a preview
+// request, as reviewed by the Kubernetes API, and the HTTP status code of the
+// response when it can't be authenticated
+func authenticatePreview(
+	ctx context.Context,
+	client kubernetes.Interface,
+	r *http.Request,
+) (authenticationv1.UserInfo, int, error) {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == header || token == "" {
+		return authenticationv1.UserInfo{}, http.StatusUnauthorized, fmt.Errorf("a bearer token is required")
+	}
+
+	review, err := client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, http.StatusInternalServerError, fmt.Errorf("could not review token: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, http.StatusUnauthorized, fmt.Errorf("invalid bearer token")
+	}
+
+	return review.Status.User, 0, nil
+}
+
+// authorizePreview checks that a user may create pods in the namespace of a
+// preview, and returns the HTTP status code of the response otherwise
+func authorizePreview(
+	ctx context.Context,
+	client kubernetes.Interface,
+	user authenticationv1.UserInfo,
+	namespace string,
+) (int, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+
+	review, err := client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "create",
+				Resource:  "pods",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("could not review access: %v", err)
+	}
+	if !review.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf(
+			"%s can't create pods in namespace %s",
+			user.Username,
+			namespace,
+		)
+	}
+
+	return 0, nil
+}
+
+// parsePreviewManifest parses a Pod or workload manifest, in JSON or YAML
+func parsePreviewManifest(manifest []byte) ([]byte, *unstructured.Unstructured, error) {
+	objectJSON, err := yaml.YAMLToJSON(manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	object := &unstructured.Unstructured{}
+	if err := object.UnmarshalJSON(objectJSON); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+
+	return objectJSON, object, nil
+}
+
+// previewNamespace returns the namespace of a preview: the requested one, then
+// the one of the manifest, and finally the default namespace
+func previewNamespace(object *unstructured.Unstructured, namespace string) string {
+	if namespace == "" {
+		namespace = object.GetNamespace()
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	return namespace
+}
+
+// previewInjection mutates a Pod or workload manifest. Workloads go through
+// the admission of the workload itself, then their pod template through the
+// admission of a pod.
+func previewInjection(
+	sidecarInjectorConfig SidecarInjectorConfig,
+	manifest []byte,
+	namespace string,
+) (*PreviewResponse, error) {
+	objectJSON, object, err := parsePreviewManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	namespace = previewNamespace(object, namespace)
+
+	gvk := object.GroupVersionKind()
+	response := &PreviewResponse{Patch: []rfc6902PatchOperation{}}
+	templatePath, isWorkload := podTemplatePaths[gvk.Kind]
+	switch {
+	case gvk.Group == "" && gvk.Kind == "Pod":
+	case isWorkload:
+		// Deployments, StatefulSets and DaemonSets are stamped with the sidecar
+		// images hash when upgrades are enabled
+		resource := metav1.GroupVersionResource{
+			Group:    gvk.Group,
+			Version:  gvk.Version,
+			Resource: strings.ToLower(gvk.Kind) + "s",
+		}
+		if !isWorkloadResource(resource) {
+			break
+		}
+		objectJSON, err = previewAdmission(
+			sidecarInjectorConfig,
+			previewRequest(namespace, object, resource, objectJSON),
+			objectJSON,
+			"",
+			response,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := object.UnmarshalJSON(objectJSON); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kind %s, expecting a Pod or a workload", gvk.Kind)
+	}
+
+	podJSON := objectJSON
+	pointer := ""
+	if isWorkload {
+		template, found, err := unstructured.NestedMap(object.Object, templatePath...)
+		if err != nil || !found {
+			return nil, fmt.Errorf("%s %s has no pod template", gvk.Kind, object.GetName())
+		}
+		if podJSON, err = json.Marshal(template); err != nil {
+			return nil, err
+		}
+		for _, field := range templatePath {
+			pointer += "/" + field
+		}
+	}
+
+	tokenPatch, err := serviceAccountTokenPatch(podJSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod: %v", err)
+	}
+	if len(tokenPatch) > 0 {
+		if objectJSON, _, err = applyPatchAt(objectJSON, pointer, tokenPatch); err != nil {
+			return nil, err
+		}
+		if podJSON, _, err = applyPatchAt(podJSON, "", tokenPatch); err != nil {
+			return nil, err
+		}
+		response.Warnings = append(
+			response.Warnings,
+			"the service account token is mounted as by the ServiceAccount admission controller",
+		)
+	}
+	objectJSON, err = previewAdmission(
+		sidecarInjectorConfig,
+		previewRequest(
+			namespace,
+			object,
+			metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			podJSON,
+		),
+		objectJSON,
+		pointer,
+		response,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(objectJSON, &response.Object); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// previewRequest returns the dry run admission request creating an object
+func previewRequest(
+	namespace string,
+	object *unstructured.Unstructured,
+	resource metav1.GroupVersionResource,
+	raw []byte,
+) *admissionv1.AdmissionRequest {
+	dryRun := true
+	kind := object.GroupVersionKind()
+	if resource.Resource == "pods" {
+		kind.Group, kind.Version, kind.Kind = "", "v1", "Pod"
+	}
+
+	request := &admissionv1.AdmissionRequest{
+		UID:       types.UID("preview"),
+		Kind:      metav1.GroupVersionKind{Group: kind.Group, Version: kind.Version, Kind: kind.Kind},
+		Resource:  resource,
+		Name:      object.GetName(),
+		Namespace: namespace,
+		Operation: admissionv1.Create,
+		DryRun:    &dryRun,
+	}
+	request.Object.Raw = raw
+
+	return request
+}
+
+// previewAdmission runs an admission request through the sidecar injector
+// logic and applies its patch to the manifest, at the pointer of the object
+// of the request in the manifest
+func previewAdmission(
+	sidecarInjectorConfig SidecarInjectorConfig,
+	req *admissionv1.AdmissionRequest,
+	manifest []byte,
+	pointer string,
+	response *PreviewResponse,
+) ([]byte, error) {
+	admissionResponse := HandleAdmissionRequest(sidecarInjectorConfig, req)
+	if admissionResponse.Result != nil {
+		return nil, fmt.Errorf("%s", admissionResponse.Result.Message)
+	}
+	response.Warnings = append(response.Warnings, admissionResponse.Warnings...)
+	if len(admissionResponse.Patch) == 0 {
+		return manifest, nil
+	}
+
+	var patch []rfc6902PatchOperation
+	if err := json.Unmarshal(admissionResponse.Patch, &patch); err != nil {
+		return nil, err
+	}
+	manifest, patch, err := applyPatchAt(manifest, pointer, patch)
+	if err != nil {
+		return nil, err
+	}
+	response.Patch = append(response.Patch, patch...)
+
+	return manifest, nil
+}
+
+// applyPatchAt applies a patch to the object at a pointer of a manifest, and
+// returns the patched manifest with the operations at their paths in the
+// manifest
+func applyPatchAt(
+	manifest []byte,
+	pointer string,
+	patch []rfc6902PatchOperation,
+) ([]byte, []rfc6902PatchOperation, error) {
+	relocated := make([]rfc6902PatchOperation, len(patch))
+	for i, operation := range patch {
+		operation.Path = pointer + operation.Path
+		if operation.From != "" {
+			operation.From = pointer + operation.From
+		}
+		relocated[i] = operation
+	}
+
+	patchBytes, err := json.Marshal(relocated)
+	if err != nil {
+		return nil, nil, err
+	}
+	decoded, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	patched, err := decoded.Apply(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return patched, relocated, nil
+}
+
+// serviceAccountTokenPatch returns the patch mounting the service account
+// token in the containers of a pod, as the ServiceAccount admission controller
+// does before the mutating webhooks run, when the pod doesn't mount it and
+// doesn't opt out of it
+func serviceAccountTokenPatch(podJSON []byte) ([]rfc6902PatchOperation, error) {
+	var pod corev1.Pod
+	if err := json.Unmarshal(podJSON, &pod); err != nil {
+		return nil, err
+	}
+	automount := pod.Spec.AutomountServiceAccountToken
+	if automount != nil && !*automount {
+		return nil, nil
+	}
+	if _, err := getServiceAccountTokenVolumeName(&pod); err == nil {
+		return nil, nil
+	}
+
+	expirationSeconds := int64(3607)
+	mutated := pod.DeepCopy()
+	mutated.Spec.Volumes = append(mutated.Spec.Volumes, corev1.Volume{
+		Name: previewServiceAccountVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+						Path:              "token",
+						ExpirationSeconds: &expirationSeconds,
+					}},
+					{ConfigMap: &corev1.ConfigMapProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+						Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+					}},
+					{DownwardAPI: &corev1.DownwardAPIProjection{
+						Items: []corev1.DownwardAPIVolumeFile{{
+							Path:     "namespace",
+							FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.namespace"},
+						}},
+					}},
+				},
+			},
+		},
+	})
+	volumeMount := corev1.VolumeMount{
+		Name:      previewServiceAccountVolume,
+		MountPath: serviceAccountTokenMountPath,
+		ReadOnly:  true,
+	}
+	for i := range mutated.Spec.InitContainers {
+		mutated.Spec.InitContainers[i].VolumeMounts = append(mutated.Spec.InitContainers[i].VolumeMounts, volumeMount)
+	}
+	for i := range mutated.Spec.Containers {
+		mutated.Spec.Containers[i].VolumeMounts = append(mutated.Spec.Containers[i].VolumeMounts, volumeMount)
+	}
+
+	from, err := toJSONValue(&pod)
+	if err != nil {
+		return nil, err
+	}
+	to, err := toJSONValue(mutated)
+	if err != nil {
+		return nil, err
+	}
+	return diffJSON("", from, to), nil
+}
@@ -0,0 +1,216 @@
+package inject
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+// Port of the health endpoints of Secretless
+const secretlessHealthPort = 5335
+
+// Kinds of container probes, as named in the `conjur.org/<kind>-probe`
+// annotations and in the `disable` list of the configuration file
+const (
+	probeKindLiveness  = "liveness"
+	probeKindReadiness = "readiness"
+	probeKindStartup   = "startup"
+)
+
+var probeKinds = []string{probeKindLiveness, probeKindReadiness, probeKindStartup}
+
+// ContainerProbes are the probes set on the containers of one inject type
+type ContainerProbes struct {
+	LivenessProbe  *corev1.Probe `json:"livenessProbe,omitempty"`
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+	StartupProbe   *corev1.Probe `json:"startupProbe,omitempty"`
+	// Kinds of probes left out, e.g. to drop a built-in default
+	Disable []string `json:"disable,omitempty"`
+}
+
+func (probes ContainerProbes) validate() error {
+	for _, kind := range probeKinds {
+		if err := validateProbe(probes.get(kind)); err != nil {
+			return fmt.Errorf("%s probe: %v", kind, err)
+		}
+	}
+	for _, kind := range probes.Disable {
+		if !isProbeKind(kind) {
+			return fmt.Errorf("unknown probe kind %q in disable", kind)
+		}
+	}
+
+	return nil
+}
+
+// get returns the probe of the given kind
+func (probes ContainerProbes) get(kind string) *corev1.Probe {
+	switch kind {
+	case probeKindLiveness:
+		return probes.LivenessProbe
+	case probeKindReadiness:
+		return probes.ReadinessProbe
+	default:
+		return probes.StartupProbe
+	}
+}
+
+// set sets the probe of the given kind, nil removing it
+func (probes *ContainerProbes) set(kind string, probe *corev1.Probe) {
+	switch kind {
+	case probeKindLiveness:
+		probes.LivenessProbe = probe
+	case probeKindReadiness:
+		probes.ReadinessProbe = probe
+	default:
+		probes.StartupProbe = probe
+	}
+}
+
+// defaultProbes are the built-in probes of the injected containers, by
+// inject type. Only Secretless gets some, as it reports its health over HTTP:
+// probing the Authenticator or Secrets Provider would take an exec probe,
+// whose command their images aren't guaranteed to provide, so their probes
+// are left to the configuration file and the annotations.
+var defaultProbes = map[string]ContainerProbes{
+	"secretless": {
+		LivenessProbe:  httpGetProbe("/live", secretlessHealthPort),
+		ReadinessProbe: httpGetProbe("/ready", secretlessHealthPort),
+	},
+}
+
+func httpGetProbe(path string, port int) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt(port),
+			},
+		},
+	}
+}
+
+// probesForType returns the probes of the containers of an inject type: the
+// built-in defaults, unless left out, replaced kind by kind by those of the
+// configuration file
+func probesForType(
+	config map[string]ContainerProbes,
+	injectType string,
+	builtIn bool,
+) ContainerProbes {
+	var probes ContainerProbes
+	if builtIn {
+		probes = defaultProbes[injectType]
+	}
+	configured, ok := config[injectType]
+	if !ok {
+		return probes
+	}
+
+	for _, kind := range probeKinds {
+		if probe := configured.get(kind); probe != nil {
+			probes.set(kind, probe)
+		}
+	}
+	for _, kind := range configured.Disable {
+		probes.set(kind, nil)
+	}
+
+	return probes
+}
+
+// getProbeOverrides applies the `conjur.org/<kind>-probe` annotations of a pod
+// to the probes of an inject type. Their value is a probe in YAML or JSON, or
+// `none` to leave the probe out.
+func getProbeOverrides(pod *corev1.Pod, probes ContainerProbes) (ContainerProbes, error) {
+	for _, kind := range probeKinds {
+		key := probeAnnotationKey(kind)
+		value, err := getAnnotation(&pod.ObjectMeta, key)
+		if err != nil {
+			continue
+		}
+		if value == "none" {
+			probes.set(kind, nil)
+			continue
+		}
+
+		probe := &corev1.Probe{}
+		if err := yaml.UnmarshalStrict([]byte(value), probe); err != nil {
+			return probes, fmt.Errorf("%s value is not a valid probe: %v", key, err)
+		}
+		if err := validateProbe(probe); err != nil {
+			return probes, fmt.Errorf("%s value is not a valid probe: %v", key, err)
+		}
+		probes.set(kind, probe)
+	}
+
+	return probes, nil
+}
+
+// applyProbes sets the probes on the injected sidecar containers, keeping
+// those already defined by a sidecar template. Init containers can't have
+// probes.
+func applyProbes(sidecarConfig *PatchConfig, probes ContainerProbes) {
+	for i := range sidecarConfig.Containers {
+		container := &sidecarConfig.Containers[i]
+		if container.LivenessProbe == nil {
+			container.LivenessProbe = probes.LivenessProbe.DeepCopy()
+		}
+		if container.ReadinessProbe == nil {
+			container.ReadinessProbe = probes.ReadinessProbe.DeepCopy()
+		}
+		if container.StartupProbe == nil {
+			container.StartupProbe = probes.StartupProbe.DeepCopy()
+		}
+	}
+}
+
+// validateProbe checks that a probe has exactly one handler
+func validateProbe(probe *corev1.Probe) error {
+	if probe == nil {
+		return nil
+	}
+
+	handlers := 0
+	if probe.Exec != nil {
+		handlers++
+	}
+	if probe.HTTPGet != nil {
+		handlers++
+	}
+	if probe.TCPSocket != nil {
+		handlers++
+	}
+	if probe.GRPC != nil {
+		handlers++
+	}
+	if handlers != 1 {
+		return fmt.Errorf("exactly one of exec, httpGet, tcpSocket and grpc must be set")
+	}
+
+	return nil
+}
+
+func isProbeKind(kind string) bool {
+	for _, probeKind := range probeKinds {
+		if kind == probeKind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// probeAnnotationKey returns the annotation overriding the probe of a kind
+func probeAnnotationKey(kind string) string {
+	switch kind {
+	case probeKindLiveness:
+		return annotationLivenessProbeKey
+	case probeKindReadiness:
+		return annotationReadinessProbeKey
+	default:
+		return annotationStartupProbeKey
+	}
+}
@@ -0,0 +1,226 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testAccessTokenProbe() *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"test", "-f", "/run/conjur/conjur-access-token"},
+			},
+		},
+	}
+}
+
+func TestProbesForType(t *testing.T) {
+	customReadiness := httpGetProbe("/healthz", 8080)
+	config := map[string]ContainerProbes{
+		"secretless": {
+			ReadinessProbe: customReadiness,
+			Disable:        []string{"liveness"},
+		},
+		"authenticator": {
+			StartupProbe: testAccessTokenProbe(),
+		},
+	}
+
+	secretless := probesForType(config, "secretless", true)
+	assert.Nil(t, secretless.LivenessProbe)
+	assert.Equal(t, customReadiness, secretless.ReadinessProbe)
+
+	assert.Equal(t, defaultProbes["secretless"], probesForType(nil, "secretless", true))
+	assert.Equal(t, ContainerProbes{}, probesForType(nil, "secretless", false))
+
+	// The Authenticator and Secrets Provider only get the configured probes
+	assert.Equal(t, ContainerProbes{StartupProbe: testAccessTokenProbe()}, probesForType(config, "authenticator", true))
+	assert.Equal(t, ContainerProbes{}, probesForType(config, "secrets-provider", true))
+	assert.Equal(t, ContainerProbes{}, probesForType(config, "my-template", true))
+}
+
+func TestGetProbeOverrides(t *testing.T) {
+	var testCases = []struct {
+		description string
+		annotations map[string]string
+		expected    ContainerProbes
+		errContains string
+	}{
+		{
+			description: "no override",
+			annotations: map[string]string{},
+			expected:    defaultProbes["secretless"],
+		},
+		{
+			description: "probe removed",
+			annotations: map[string]string{"conjur.org/liveness-probe": "none"},
+			expected: ContainerProbes{
+				ReadinessProbe: defaultProbes["secretless"].ReadinessProbe,
+			},
+		},
+		{
+			description: "probe replaced",
+			annotations: map[string]string{
+				"conjur.org/readiness-probe": `{"tcpSocket": {"port": 5432}, "periodSeconds": 5}`,
+				"conjur.org/startup-probe":   "httpGet:\n  path: /ready\n  port: 5335\nfailureThreshold: 30\n",
+			},
+			expected: ContainerProbes{
+				LivenessProbe: defaultProbes["secretless"].LivenessProbe,
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(5432)},
+					},
+					PeriodSeconds: 5,
+				},
+				StartupProbe: &corev1.Probe{
+					ProbeHandler:     httpGetProbe("/ready", secretlessHealthPort).ProbeHandler,
+					FailureThreshold: 30,
+				},
+			},
+		},
+		{
+			description: "unknown field",
+			annotations: map[string]string{"conjur.org/readiness-probe": `{"tcpSockets": {"port": 5432}}`},
+			errContains: "conjur.org/readiness-probe value is not a valid probe",
+		},
+		{
+			description: "no handler",
+			annotations: map[string]string{"conjur.org/liveness-probe": `{"periodSeconds": 5}`},
+			errContains: "exactly one of exec, httpGet, tcpSocket and grpc must be set",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+
+			probes, err := getProbeOverrides(pod, defaultProbes["secretless"])
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, probes)
+		})
+	}
+}
+
+func TestProbesConfigValidation(t *testing.T) {
+	assert.NoError(t, ConfigFile{
+		Probes: map[string]ContainerProbes{"secrets-provider": {Disable: []string{"readiness"}}},
+	}.validate())
+
+	err := ConfigFile{
+		Probes: map[string]ContainerProbes{"secretles": {}},
+	}.validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `probes set for unknown inject type "secretles"`)
+	}
+
+	err = ConfigFile{
+		Probes: map[string]ContainerProbes{"secretless": {Disable: []string{"ready"}}},
+	}.validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `unknown probe kind "ready" in disable`)
+	}
+}
+
+func TestProbesInjection(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.ConfigFile.Probes = map[string]ContainerProbes{
+		"authenticator": {
+			LivenessProbe: testAccessTokenProbe(),
+		},
+	}
+
+	req, err := newTestAdmissionRequest("./testdata/authenticator-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err = addTestAnnotations(req, map[string]string{
+		"conjur.org/authenticator.readiness-probe": "none",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+	sidecar := pod.Spec.Containers[len(pod.Spec.Containers)-1]
+	assert.Equal(t, testAccessTokenProbe(), sidecar.LivenessProbe)
+	assert.Nil(t, sidecar.ReadinessProbe)
+	assert.Nil(t, sidecar.StartupProbe)
+	assert.NotContains(t, pod.Annotations, "conjur.org/authenticator.readiness-probe")
+}
+
+func TestDefaultProbesInjection(t *testing.T) {
+	var testCases = []struct {
+		description string
+		annotations map[string]string
+		expected    ContainerProbes
+	}{
+		{
+			description: "built-in probes",
+			expected:    defaultProbes["secretless"],
+		},
+		{
+			description: "built-in probes left out",
+			annotations: map[string]string{"conjur.org/default-probes": "false"},
+		},
+		{
+			description: "built-in probes left out, annotated probe kept",
+			annotations: map[string]string{
+				"conjur.org/default-probes":  "false",
+				"conjur.org/readiness-probe": "tcpSocket:\n  port: 5432\n",
+			},
+			expected: ContainerProbes{
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(5432)},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req, err := newTestAdmissionRequest("./testdata/secretless-annotated-pod.json")
+			if !assert.NoError(t, err) {
+				return
+			}
+			req, err = addTestAnnotations(req, tc.annotations)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			mod, err := applyPatchToAdmissionRequest(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var pod corev1.Pod
+			if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+				return
+			}
+			sidecar := pod.Spec.Containers[len(pod.Spec.Containers)-1]
+			assert.Equal(t, tc.expected.LivenessProbe, sidecar.LivenessProbe)
+			assert.Equal(t, tc.expected.ReadinessProbe, sidecar.ReadinessProbe)
+			assert.Nil(t, sidecar.StartupProbe)
+		})
+	}
+}
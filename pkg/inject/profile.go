@@ -0,0 +1,136 @@
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InjectionProfile bundles the settings of a commonly used injection under a
+// name, so that a single `conjur.org/injection-profile` annotation replaces
+// the full annotation set
+type InjectionProfile struct {
+	InjectType     string `json:"injectType,omitempty"`
+	ContainerMode  string `json:"containerMode,omitempty"`
+	ContainerName  string `json:"containerName,omitempty"`
+	ContainerImage string `json:"containerImage,omitempty"`
+	// Any other `conjur.org/*` annotations
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Resources of the injected containers
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Volumes added to the pod, and mounted into the injected containers
+	Volumes      []corev1.Volume      `json:"volumes,omitempty"`
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+}
+
+func (profile InjectionProfile) validate() error {
+	switch profile.ContainerMode {
+	case "sidecar", "init", "":
+	default:
+		return fmt.Errorf("containerMode value (%s) not supported", profile.ContainerMode)
+	}
+
+	volumes := map[string]bool{}
+	for _, volume := range profile.Volumes {
+		volumes[volume.Name] = true
+	}
+	for _, volumeMount := range profile.VolumeMounts {
+		if !volumes[volumeMount.Name] {
+			return fmt.Errorf("volumeMount %s refers to a volume not defined by the profile", volumeMount.Name)
+		}
+	}
+
+	return nil
+}
+
+// toAnnotations returns the pod annotations equivalent to the profile
+func (profile InjectionProfile) toAnnotations() map[string]string {
+	annotations := map[string]string{}
+	for key, value := range profile.Annotations {
+		if strings.HasPrefix(key, conjurAnnotationPrefix) && key != annotationStatusKey {
+			annotations[key] = value
+		}
+	}
+
+	annotations[annotationInjectKey] = "true"
+	for key, value := range map[string]string{
+		annotationInjectTypeKey:     profile.InjectType,
+		annotationContainerModeKey:  profile.ContainerMode,
+		annotationContainerNameKey:  profile.ContainerName,
+		annotationContainerImageKey: profile.ContainerImage,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+
+	return annotations
+}
+
+// lookupProfile returns the named profile, failing for unknown names
+func lookupProfile(
+	profiles map[string]InjectionProfile,
+	name string,
+) (*InjectionProfile, error) {
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown injection profile %q in %s annotation", name, annotationInjectionProfileKey)
+	}
+
+	return &profile, nil
+}
+
+// applyProfileToPatchConfig sets the resources, volumes and volume mounts of
+// the profile on the containers of the PatchConfig. The resources of the
+// profile override those of the containers one by one, keeping the requests
+// and limits the profile doesn't set, e.g. those of sidecar templates.
+func applyProfileToPatchConfig(profile *InjectionProfile, sidecarConfig *PatchConfig) {
+	for _, containers := range [][]corev1.Container{
+		sidecarConfig.InitContainers,
+		sidecarConfig.Containers,
+	} {
+		for i := range containers {
+			resources := &containers[i].Resources
+			resources.Requests = mergeResourceLists(resources.Requests, profile.Resources.Requests)
+			resources.Limits = mergeResourceLists(resources.Limits, profile.Resources.Limits)
+			if len(profile.Resources.Claims) > 0 {
+				resources.Claims = append([]corev1.ResourceClaim{}, profile.Resources.Claims...)
+			}
+			containers[i].VolumeMounts = append(
+				containers[i].VolumeMounts,
+				profile.VolumeMounts...,
+			)
+		}
+	}
+
+	sidecarConfig.Volumes = append(sidecarConfig.Volumes, profile.Volumes...)
+}
+
+// mergeResourceLists returns a copy of a resource list with the quantities of
+// another one set, or the list itself when the other one is empty
+func mergeResourceLists(list, overrides corev1.ResourceList) corev1.ResourceList {
+	if len(overrides) == 0 {
+		return list
+	}
+
+	merged := corev1.ResourceList{}
+	for name, quantity := range list {
+		merged[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range overrides {
+		merged[name] = quantity.DeepCopy()
+	}
+	return merged
+}
+
+// firstValue returns the value of the key in the first map that sets it
+func firstValue(key string, maps ...map[string]string) string {
+	for _, m := range maps {
+		if value, ok := m[key]; ok {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,220 @@
+package inject
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestProfiles() map[string]InjectionProfile {
+	return map[string]InjectionProfile{
+		"authn-sidecar": {
+			InjectType:    "authenticator",
+			ContainerMode: "sidecar",
+			ContainerName: "overridden-by-pod",
+			Annotations: map[string]string{
+				"conjur.org/conjurAuthConfig":      "conjur",
+				"conjur.org/conjurConnConfig":      "conjur",
+				"conjur.org/conjur-inject-volumes": "nginx-2",
+			},
+		},
+	}
+}
+
+func TestProfileInjection(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.ConfigFile = ConfigFile{Profiles: newTestProfiles()}
+
+	// The pod names its profile and overrides the container name
+	req, err := newTestAdmissionRequest("./testdata/profile-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expectedMod, err := ioutil.ReadFile("./testdata/authenticator-mutated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.JSONEq(t, string(expectedMod), string(mod))
+}
+
+func TestUnknownProfile(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+
+	req, err := newTestAdmissionRequest("./testdata/profile-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	assert.EqualError(
+		t,
+		err,
+		`Mutation failed for pod , in namespace dummy, due to unknown injection profile "authn-sidecar" in conjur.org/injection-profile annotation`,
+	)
+}
+
+func TestApplyProfileToPatchConfig(t *testing.T) {
+	profile := &InjectionProfile{
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+		},
+		Volumes: []corev1.Volume{
+			{Name: "ca", VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "ca"},
+				},
+			}},
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: "ca", MountPath: "/etc/ca"}},
+	}
+	sidecarConfig := &PatchConfig{
+		Containers: []corev1.Container{{Name: "authenticator"}},
+	}
+
+	applyProfileToPatchConfig(profile, sidecarConfig)
+
+	assert.Equal(t, profile.Resources, sidecarConfig.Containers[0].Resources)
+	assert.Equal(t, profile.VolumeMounts, sidecarConfig.Containers[0].VolumeMounts)
+	assert.Equal(t, profile.Volumes, sidecarConfig.Volumes)
+}
+
+func TestApplyProfileResources(t *testing.T) {
+	templateResources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("32Mi")},
+	}
+
+	var testCases = []struct {
+		description string
+		resources   corev1.ResourceRequirements
+		expected    corev1.ResourceRequirements
+	}{
+		{
+			description: "profile without resources",
+			expected:    templateResources,
+		},
+		{
+			description: "profile overriding a limit",
+			resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("64Mi"),
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+				},
+			},
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("64Mi"),
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			// A sidecar template setting resources
+			sidecarConfig := &PatchConfig{
+				Containers: []corev1.Container{
+					{Name: "log-shipper", Resources: *templateResources.DeepCopy()},
+				},
+			}
+
+			applyProfileToPatchConfig(&InjectionProfile{Resources: tc.resources}, sidecarConfig)
+
+			assert.Equal(t, tc.expected, sidecarConfig.Containers[0].Resources)
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	var testCases = []struct {
+		description string
+		content     string
+		errContains string
+	}{
+		{
+			description: "valid profile",
+			content: `
+profiles:
+  sp-file-sidecar:
+    injectType: secrets-provider
+    containerMode: sidecar
+    annotations:
+      conjur.org/secrets-destination: file
+`,
+		},
+		{
+			description: "unknown field",
+			content: `
+profiles:
+  sp-file-sidecar:
+    injecType: secrets-provider
+`,
+			errContains: "unknown field",
+		},
+		{
+			description: "unsupported container mode",
+			content: `
+profiles:
+  broken:
+    containerMode: sideways
+`,
+			errContains: `profile "broken": containerMode value (sideways) not supported`,
+		},
+		{
+			description: "mount of undefined volume",
+			content: `
+profiles:
+  broken:
+    volumeMounts:
+    - name: missing
+      mountPath: /missing
+`,
+			errContains: "volumeMount missing refers to a volume not defined by the profile",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if !assert.NoError(t, os.WriteFile(path, []byte(tc.content), 0600)) {
+				return
+			}
+
+			config, err := LoadConfigFile(path)
+			if tc.errContains != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errContains)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "secrets-provider", config.Profiles["sp-file-sidecar"].InjectType)
+		})
+	}
+}
+
+func TestProfileToAnnotations(t *testing.T) {
+	metadata := metav1.ObjectMeta{
+		Annotations: map[string]string{annotationContainerNameKey: "custom"},
+	}
+
+	mergeAnnotationDefaults(&metadata, newTestProfiles()["authn-sidecar"].toAnnotations())
+
+	assert.Equal(t, "custom", metadata.Annotations[annotationContainerNameKey])
+	assert.Equal(t, "true", metadata.Annotations[annotationInjectKey])
+	assert.Equal(t, "authenticator", metadata.Annotations[annotationInjectTypeKey])
+}
@@ -0,0 +1,80 @@
+package inject
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/cyberark/sidecar-injector/pkg/version"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// now returns the time recorded in `conjur.org/injected-at`, and is replaced
+// by the tests
+var now = time.Now
+
+// provenanceAnnotations returns the annotations recording how a pod was
+// injected: the version of the sidecar injector, the inject types, the images
+// of the added containers by inject type, the added volumes, the hash of
+// everything added to the pod and the time of the injection
+func provenanceAnnotations(
+	injectTypes []string,
+	sidecarConfigs []*PatchConfig,
+	sidecarConfig *PatchConfig,
+) (map[string]string, error) {
+	var images []string
+	for i, injectType := range injectTypes {
+		seen := map[string]bool{}
+		for _, containers := range [][]corev1.Container{
+			sidecarConfigs[i].InitContainers,
+			sidecarConfigs[i].Containers,
+		} {
+			for _, container := range containers {
+				if container.Image == "" || seen[container.Image] {
+					continue
+				}
+				seen[container.Image] = true
+				images = append(images, injectType+"="+container.Image)
+			}
+		}
+	}
+
+	configHash, err := patchConfigHash(sidecarConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := map[string]string{
+		annotationInjectorVersionKey: version.FullVersionName,
+		annotationInjectedTypesKey:   strings.Join(injectTypes, ","),
+		annotationInjectedImagesKey:  strings.Join(images, ","),
+		annotationInjectedConfigKey:  configHash,
+		annotationInjectedAtKey:      now().UTC().Format(time.RFC3339),
+	}
+	// The volumes shared with the receiving containers are among them, which
+	// ephemeral containers targeting these containers mount too
+	if len(sidecarConfig.Volumes) > 0 {
+		volumes := make([]string, len(sidecarConfig.Volumes))
+		for i, volume := range sidecarConfig.Volumes {
+			volumes[i] = volume.Name
+		}
+		annotations[annotationInjectedVolumesKey] = strings.Join(volumes, ",")
+	}
+
+	return annotations, nil
+}
+
+// patchConfigHash returns the hash of the containers, volumes, mounts, probes
+// and environment added to a pod, which changes with the configuration file,
+// the injection profile and the annotations that resolved them
+func patchConfigHash(sidecarConfig *PatchConfig) (string, error) {
+	configJSON, err := json.Marshal(sidecarConfig)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(configJSON)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
@@ -0,0 +1,67 @@
+package inject
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestProvenanceAnnotations(t *testing.T) {
+	sidecarConfigs := []*PatchConfig{
+		{
+			InitContainers: []corev1.Container{{Name: "init", Image: "secrets-provider-image"}},
+			Containers:     []corev1.Container{{Name: "sidecar", Image: "secrets-provider-image"}},
+		},
+		{
+			Containers: []corev1.Container{
+				{Name: "shipper", Image: "log-shipper-image"},
+				{Name: "rotator", Image: "log-rotator-image"},
+			},
+		},
+	}
+	merged := &PatchConfig{
+		InitContainers: sidecarConfigs[0].InitContainers,
+		Containers:     append(sidecarConfigs[0].Containers, sidecarConfigs[1].Containers...),
+	}
+
+	annotations, err := provenanceAnnotations(
+		[]string{"secrets-provider", "audit-log-shipper"},
+		sidecarConfigs,
+		merged,
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	configHash, _ := patchConfigHash(merged)
+	assert.Equal(t, map[string]string{
+		"conjur.org/injector-version":     "unset-unset",
+		"conjur.org/injected-types":       "secrets-provider,audit-log-shipper",
+		"conjur.org/injected-images":      "secrets-provider=secrets-provider-image,audit-log-shipper=log-shipper-image,audit-log-shipper=log-rotator-image",
+		"conjur.org/injected-config-hash": configHash,
+		"conjur.org/injected-at":          "2021-01-01T00:00:00Z",
+	}, annotations)
+}
+
+func TestPatchConfigHash(t *testing.T) {
+	sidecarConfig := &PatchConfig{
+		Containers: []corev1.Container{{Name: "sidecar", Image: "secretless-image"}},
+	}
+	hash, err := patchConfigHash(sidecarConfig)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, hash, 16)
+
+	// Warnings aren't added to the pod
+	sidecarConfig.Warnings = []string{"warning"}
+	sameHash, _ := patchConfigHash(sidecarConfig)
+	assert.Equal(t, hash, sameHash)
+
+	sidecarConfig.Containers[0].Resources.Limits = corev1.ResourceList{
+		corev1.ResourceMemory: resource.MustParse("64Mi"),
+	}
+	otherHash, _ := patchConfigHash(sidecarConfig)
+	assert.NotEqual(t, hash, otherHash)
+}
@@ -0,0 +1,179 @@
+package inject
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	trustedCAVolumeName       = "conjur-trusted-ca"
+	trustedCABundleFile       = "tls-ca-bundle.pem"
+	defaultTrustedCAKey       = "ca-bundle.crt"
+	defaultTrustedCAMountPath = "/etc/pki/ca-trust/extracted/pem"
+)
+
+// ProxyConfig is the cluster egress proxy propagated to injected containers
+type ProxyConfig struct {
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy,omitempty"`
+	// By default the Conjur host is added to NO_PROXY. Set to send the
+	// traffic to Conjur through the proxy as well.
+	ProxyConjur bool `json:"proxyConjur,omitempty"`
+}
+
+// TrustedCABundleConfig is a ConfigMap holding the CA certificates trusted by
+// injected containers, such as the one filled by OpenShift for the
+// `config.openshift.io/inject-trusted-cabundle` label
+type TrustedCABundleConfig struct {
+	ConfigMap string `json:"configMap"`
+	// Key of the PEM bundle in the ConfigMap, defaults to ca-bundle.crt
+	Key string `json:"key,omitempty"`
+	// Directory the bundle is mounted in, defaults to /etc/pki/ca-trust/extracted/pem
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+func (config *TrustedCABundleConfig) validate() error {
+	if config == nil {
+		return nil
+	}
+	if config.ConfigMap == "" {
+		return fmt.Errorf("trustedCABundle requires a configMap")
+	}
+	if config.MountPath != "" && !path.IsAbs(config.MountPath) {
+		return fmt.Errorf("trustedCABundle mountPath %s must be absolute", config.MountPath)
+	}
+
+	return nil
+}
+
+// envVars returns the proxy variables of the injected containers, adding
+// the Conjur host to NO_PROXY unless it is already covered
+func (config ProxyConfig) envVars(conjurURL string) []corev1.EnvVar {
+	if config.HTTPProxy == "" && config.HTTPSProxy == "" {
+		return nil
+	}
+
+	noProxy := config.NoProxy
+	if host := urlHost(conjurURL); host != "" && !config.ProxyConjur && !noProxyCovers(noProxy, host) {
+		if noProxy != "" {
+			noProxy += ","
+		}
+		noProxy += host
+	}
+
+	var envVars []corev1.EnvVar
+	for _, name := range []string{"HTTP_PROXY", "http_proxy"} {
+		if config.HTTPProxy != "" {
+			envVars = append(envVars, envVarFromLiteral(name, config.HTTPProxy))
+		}
+	}
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy"} {
+		if config.HTTPSProxy != "" {
+			envVars = append(envVars, envVarFromLiteral(name, config.HTTPSProxy))
+		}
+	}
+	for _, name := range []string{"NO_PROXY", "no_proxy"} {
+		if noProxy != "" {
+			envVars = append(envVars, envVarFromLiteral(name, noProxy))
+		}
+	}
+
+	return envVars
+}
+
+// urlHost returns the host name of a URL, without its port
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
+
+// noProxyCovers tells whether a NO_PROXY list matches a host, following the
+// conventions of Go's net/http: `*` matches everything, and an entry matches
+// the host itself and its subdomains, with or without a leading dot
+func noProxyCovers(noProxy, host string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// conjurApplianceURL returns the Conjur URL configured for the injector
+func conjurApplianceURL() string {
+	if value := os.Getenv("CONJUR_APPLIANCE_URL"); value != "" {
+		return value
+	}
+
+	return os.Getenv("conjurApplianceUrl")
+}
+
+// applyTrustedCABundle mounts the CA bundle ConfigMap into all the containers
+// of the PatchConfig, and points SSL_CERT_FILE at it
+func applyTrustedCABundle(config *TrustedCABundleConfig, sidecarConfig *PatchConfig) {
+	if config == nil {
+		return
+	}
+
+	key := config.Key
+	if key == "" {
+		key = defaultTrustedCAKey
+	}
+	mountPath := config.MountPath
+	if mountPath == "" {
+		mountPath = defaultTrustedCAMountPath
+	}
+
+	sidecarConfig.Volumes = append(sidecarConfig.Volumes, corev1.Volume{
+		Name: trustedCAVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: config.ConfigMap},
+				Items: []corev1.KeyToPath{
+					{Key: key, Path: trustedCABundleFile},
+				},
+			},
+		},
+	})
+
+	for _, containers := range [][]corev1.Container{
+		sidecarConfig.InitContainers,
+		sidecarConfig.Containers,
+	} {
+		for i := range containers {
+			containers[i].VolumeMounts = append(containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      trustedCAVolumeName,
+				ReadOnly:  true,
+				MountPath: mountPath,
+			})
+			containers[i].Env = setEnvVar(
+				containers[i].Env,
+				envVarFromLiteral("SSL_CERT_FILE", path.Join(mountPath, trustedCABundleFile)),
+			)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestProxyEnvVars(t *testing.T) {
+	const conjurURL = "https://conjur-oss.conjur-oss.svc.cluster.local:443"
+
+	var testCases = []struct {
+		description     string
+		config          ProxyConfig
+		expectedNoProxy string
+	}{
+		{
+			description:     "Conjur host added to NO_PROXY",
+			config:          ProxyConfig{HTTPSProxy: "http://proxy:3128", NoProxy: "10.0.0.0/8"},
+			expectedNoProxy: "10.0.0.0/8,conjur-oss.conjur-oss.svc.cluster.local",
+		},
+		{
+			description:     "Conjur host already covered",
+			config:          ProxyConfig{HTTPSProxy: "http://proxy:3128", NoProxy: ".svc.cluster.local"},
+			expectedNoProxy: ".svc.cluster.local",
+		},
+		{
+			description:     "Conjur reached through the proxy",
+			config:          ProxyConfig{HTTPSProxy: "http://proxy:3128", ProxyConjur: true},
+			expectedNoProxy: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			env := map[string]string{}
+			for _, envVar := range tc.config.envVars(conjurURL) {
+				env[envVar.Name] = envVar.Value
+			}
+			assert.Equal(t, "http://proxy:3128", env["HTTPS_PROXY"])
+			assert.Equal(t, "http://proxy:3128", env["https_proxy"])
+			assert.NotContains(t, env, "HTTP_PROXY")
+			assert.Equal(t, tc.expectedNoProxy, env["NO_PROXY"])
+			assert.Equal(t, tc.expectedNoProxy, env["no_proxy"])
+		})
+	}
+
+	t.Run("no proxy configured", func(t *testing.T) {
+		assert.Empty(t, ProxyConfig{NoProxy: "localhost"}.envVars(conjurURL))
+	})
+}
+
+func TestNoProxyCovers(t *testing.T) {
+	assert.True(t, noProxyCovers("*", "conjur.example.com"))
+	assert.True(t, noProxyCovers("localhost, conjur.example.com", "conjur.example.com"))
+	assert.True(t, noProxyCovers("example.com", "conjur.example.com"))
+	assert.True(t, noProxyCovers(".EXAMPLE.com:443", "conjur.example.com"))
+	assert.False(t, noProxyCovers("ample.com", "conjur.example.com"))
+	assert.False(t, noProxyCovers("", "conjur.example.com"))
+}
+
+func TestProxyAndTrustedCAInjection(t *testing.T) {
+	t.Setenv("CONJUR_APPLIANCE_URL", "https://conjur.example.com")
+
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.ConfigFile = ConfigFile{
+		Proxy: ProxyConfig{
+			HTTPProxy:  "http://proxy:3128",
+			HTTPSProxy: "http://proxy:3128",
+		},
+		TrustedCABundle: &TrustedCABundleConfig{ConfigMap: "trusted-ca-bundle"},
+	}
+
+	req, err := newTestAdmissionRequest("./testdata/multi-type-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err = addTestAnnotations(req, map[string]string{
+		"conjur.org/env.NO_PROXY": "overridden-by-pod",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+
+	trustedCAVolumes := 0
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == trustedCAVolumeName {
+			trustedCAVolumes++
+			assert.Equal(t, "trusted-ca-bundle", volume.ConfigMap.Name)
+			assert.Equal(t, []corev1.KeyToPath{
+				{Key: "ca-bundle.crt", Path: "tls-ca-bundle.pem"},
+			}, volume.ConfigMap.Items)
+		}
+	}
+	assert.Equal(t, 1, trustedCAVolumes)
+
+	for _, container := range pod.Spec.Containers[2:] {
+		env := map[string]string{}
+		for _, envVar := range container.Env {
+			env[envVar.Name] = envVar.Value
+		}
+		assert.Equal(t, "http://proxy:3128", env["HTTP_PROXY"], container.Name)
+		assert.Equal(t, "conjur.example.com", env["no_proxy"], container.Name)
+		assert.Equal(t, "overridden-by-pod", env["NO_PROXY"], container.Name)
+		assert.Equal(t, "/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", env["SSL_CERT_FILE"], container.Name)
+		assert.Contains(t, container.VolumeMounts, corev1.VolumeMount{
+			Name:      trustedCAVolumeName,
+			ReadOnly:  true,
+			MountPath: "/etc/pki/ca-trust/extracted/pem",
+		}, container.Name)
+	}
+}
@@ -0,0 +1,293 @@
+package inject
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	managedByLabelKey   = "app.kubernetes.io/managed-by"
+	managedByLabelValue = "cyberark-sidecar-injector"
+)
+
+// SecretsProviderRBACConfig describes the Role and RoleBinding that grant a
+// Secrets Provider running in k8s_secrets mode access to the Kubernetes
+// Secrets it updates.
+type SecretsProviderRBACConfig struct {
+	namespace      string
+	serviceAccount string
+	secretNames    []string
+	sideEffects    sideEffects
+}
+
+// secretsProviderRBACName returns the name of the Role and RoleBinding created
+// for a service account
+func secretsProviderRBACName(serviceAccount string) string {
+	return fmt.Sprintf("%s-conjur-secrets-provider", serviceAccount)
+}
+
+// podServiceAccountName returns the service account the pod will run as
+func podServiceAccountName(pod *corev1.Pod) string {
+	if pod.Spec.ServiceAccountName != "" {
+		return pod.Spec.ServiceAccountName
+	}
+	return "default"
+}
+
+// getK8sSecretNames returns the names of the Kubernetes Secrets that the
+// Secrets Provider is expected to update. The `conjur.org/k8s-secrets`
+// annotation takes precedence over a K8S_SECRETS environment variable set on
+// any of the pod's containers.
+func getK8sSecretNames(pod *corev1.Pod) ([]string, error) {
+	var names []string
+
+	if value, err := getAnnotation(&pod.ObjectMeta, annotationK8sSecretsKey); err == nil {
+		if err := yaml.Unmarshal([]byte(value), &names); err != nil {
+			return nil, fmt.Errorf(
+				"unable to parse %s annotation as a list: %v",
+				annotationK8sSecretsKey,
+				err,
+			)
+		}
+	} else {
+		containers := append(
+			append([]corev1.Container{}, pod.Spec.InitContainers...),
+			pod.Spec.Containers...,
+		)
+		for _, container := range containers {
+			for _, env := range container.Env {
+				if env.Name == "K8S_SECRETS" {
+					names = append(names, strings.Split(env.Value, ",")...)
+				}
+			}
+		}
+	}
+
+	return uniqueSortedNames(names), nil
+}
+
+// uniqueSortedNames trims, de-duplicates and sorts a list of names, dropping
+// empty entries
+func uniqueSortedNames(names []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// validateSecretsProviderRBAC checks that the RBAC of a Secrets Provider can
+// be written, before the pod is patched
+func validateSecretsProviderRBAC(client kubernetes.Interface, cfg SecretsProviderRBACConfig) error {
+	if client == nil {
+		return fmt.Errorf("no Kubernetes client configured for RBAC creation")
+	}
+	if len(cfg.secretNames) == 0 {
+		return fmt.Errorf(
+			"no Kubernetes Secrets listed in %s annotation or K8S_SECRETS environment variable",
+			annotationK8sSecretsKey,
+		)
+	}
+
+	return nil
+}
+
+// ensureSecretsProviderRBAC makes sure a Role granting access to the listed
+// Kubernetes Secrets, and a RoleBinding of that Role to the pod's service
+// account, exist in the pod's namespace. Secrets listed by other pods of the
+// service account are kept in the Role. No objects are written when the
+// request is a dry run.
+func ensureSecretsProviderRBAC(
+	ctx context.Context,
+	client kubernetes.Interface,
+	cfg SecretsProviderRBACConfig,
+) error {
+	if err := validateSecretsProviderRBAC(client, cfg); err != nil {
+		return err
+	}
+
+	if err := ensureSecretsProviderRole(ctx, client, cfg); err != nil {
+		return err
+	}
+
+	return ensureSecretsProviderRoleBinding(ctx, client, cfg)
+}
+
+func ensureSecretsProviderRole(
+	ctx context.Context,
+	client kubernetes.Interface,
+	cfg SecretsProviderRBACConfig,
+) error {
+	name := secretsProviderRBACName(cfg.serviceAccount)
+	roles := client.RbacV1().Roles(cfg.namespace)
+
+	role, err := roles.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		role = &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: cfg.namespace,
+				Labels: map[string]string{
+					managedByLabelKey: managedByLabelValue,
+				},
+			},
+			Rules: []rbacv1.PolicyRule{secretsProviderPolicyRule(cfg.secretNames)},
+		}
+		return cfg.sideEffects.write(
+			fmt.Sprintf("creation of Role %s/%s", cfg.namespace, name),
+			func() error {
+				log.Printf("Creating Role %s/%s for Secrets %v", cfg.namespace, name, cfg.secretNames)
+				_, err := roles.Create(ctx, role, metav1.CreateOptions{})
+				return wrapRBACError("create Role", cfg.namespace, name, err)
+			},
+		)
+	}
+	if err != nil {
+		return wrapRBACError("get Role", cfg.namespace, name, err)
+	}
+
+	var secretNames []string
+	for _, rule := range role.Rules {
+		if isSecretsProviderPolicyRule(rule) {
+			secretNames = append(secretNames, rule.ResourceNames...)
+		}
+	}
+	merged := uniqueSortedNames(append(secretNames, cfg.secretNames...))
+	if len(merged) == len(uniqueSortedNames(secretNames)) {
+		return nil
+	}
+
+	var rules []rbacv1.PolicyRule
+	for _, rule := range role.Rules {
+		if !isSecretsProviderPolicyRule(rule) {
+			rules = append(rules, rule)
+		}
+	}
+	role.Rules = append(rules, secretsProviderPolicyRule(merged))
+	return cfg.sideEffects.write(
+		fmt.Sprintf("update of Role %s/%s", cfg.namespace, name),
+		func() error {
+			log.Printf("Updating Role %s/%s for Secrets %v", cfg.namespace, name, merged)
+			_, err := roles.Update(ctx, role, metav1.UpdateOptions{})
+			return wrapRBACError("update Role", cfg.namespace, name, err)
+		},
+	)
+}
+
+// ensureSecretsProviderRoleBinding makes sure the RoleBinding of the Secrets
+// Provider Role to the pod's service account exists. An existing RoleBinding
+// missing the service account gets it added, while one binding another role,
+// which can't be changed, fails the injection.
+func ensureSecretsProviderRoleBinding(
+	ctx context.Context,
+	client kubernetes.Interface,
+	cfg SecretsProviderRBACConfig,
+) error {
+	name := secretsProviderRBACName(cfg.serviceAccount)
+	roleBindings := client.RbacV1().RoleBindings(cfg.namespace)
+	roleRef := rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     "Role",
+		Name:     name,
+	}
+	subject := rbacv1.Subject{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      cfg.serviceAccount,
+		Namespace: cfg.namespace,
+	}
+
+	roleBinding, err := roleBindings.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		roleBinding = &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: cfg.namespace,
+				Labels: map[string]string{
+					managedByLabelKey: managedByLabelValue,
+				},
+			},
+			Subjects: []rbacv1.Subject{subject},
+			RoleRef:  roleRef,
+		}
+		return cfg.sideEffects.write(
+			fmt.Sprintf("creation of RoleBinding %s/%s", cfg.namespace, name),
+			func() error {
+				log.Printf("Creating RoleBinding %s/%s", cfg.namespace, name)
+				_, err := roleBindings.Create(ctx, roleBinding, metav1.CreateOptions{})
+				return wrapRBACError("create RoleBinding", cfg.namespace, name, err)
+			},
+		)
+	}
+	if err != nil {
+		return wrapRBACError("get RoleBinding", cfg.namespace, name, err)
+	}
+
+	if roleBinding.RoleRef != roleRef {
+		return fmt.Errorf(
+			"RoleBinding %s/%s binds %s %s instead of Role %s, delete it to let the injector recreate it",
+			cfg.namespace,
+			name,
+			roleBinding.RoleRef.Kind,
+			roleBinding.RoleRef.Name,
+			name,
+		)
+	}
+	for _, existing := range roleBinding.Subjects {
+		if existing.Kind == subject.Kind &&
+			existing.Name == subject.Name &&
+			(existing.Namespace == subject.Namespace || existing.Namespace == "") {
+			return nil
+		}
+	}
+
+	roleBinding.Subjects = append(roleBinding.Subjects, subject)
+	return cfg.sideEffects.write(
+		fmt.Sprintf("update of RoleBinding %s/%s", cfg.namespace, name),
+		func() error {
+			log.Printf("Adding service account %s to RoleBinding %s/%s", cfg.serviceAccount, cfg.namespace, name)
+			_, err := roleBindings.Update(ctx, roleBinding, metav1.UpdateOptions{})
+			return wrapRBACError("update RoleBinding", cfg.namespace, name, err)
+		},
+	)
+}
+
+// secretsProviderPolicyRule returns the rule required by the Secrets Provider
+// to read and update the named Kubernetes Secrets
+func secretsProviderPolicyRule(secretNames []string) rbacv1.PolicyRule {
+	return rbacv1.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"secrets"},
+		ResourceNames: secretNames,
+		Verbs:         []string{"get", "update"},
+	}
+}
+
+func isSecretsProviderPolicyRule(rule rbacv1.PolicyRule) bool {
+	return len(rule.APIGroups) == 1 && rule.APIGroups[0] == "" &&
+		len(rule.Resources) == 1 && rule.Resources[0] == "secrets"
+}
+
+func wrapRBACError(action, namespace, name string, err error) error {
+	if err == nil || k8serrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return fmt.Errorf("unable to %s %s/%s: %v", action, namespace, name, err)
+}
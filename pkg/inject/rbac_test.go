@@ -0,0 +1,198 @@
+package inject
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetK8sSecretNames(t *testing.T) {
+	var testCases = []struct {
+		description string
+		pod         corev1.Pod
+		expected    []string
+	}{
+		{
+			description: "from annotation",
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						annotationK8sSecretsKey: "- db-credentials\n- api-key\n",
+					},
+				},
+			},
+			expected: []string{"api-key", "db-credentials"},
+		},
+		{
+			description: "from K8S_SECRETS environment variable",
+			pod: corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "app",
+							Env: []corev1.EnvVar{
+								envVarFromLiteral("K8S_SECRETS", "db-credentials, api-key,db-credentials"),
+							},
+						},
+					},
+				},
+			},
+			expected: []string{"api-key", "db-credentials"},
+		},
+		{
+			description: "none listed",
+			pod:         corev1.Pod{},
+			expected:    nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			names, err := getK8sSecretNames(&tc.pod)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, names)
+		})
+	}
+}
+
+func TestEnsureSecretsProviderRBAC(t *testing.T) {
+	ctx := context.Background()
+	cfg := SecretsProviderRBACConfig{
+		namespace:      "app-ns",
+		serviceAccount: "app-sa",
+		secretNames:    []string{"db-credentials"},
+	}
+	name := secretsProviderRBACName(cfg.serviceAccount)
+	roleRef := rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     "Role",
+		Name:     name,
+	}
+	subject := rbacv1.Subject{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      "app-sa",
+		Namespace: "app-ns",
+	}
+
+	t.Run("binds a Role scoped to the listed Secrets", func(t *testing.T) {
+		client := fake.NewClientset()
+
+		assert.NoError(t, ensureSecretsProviderRBAC(ctx, client, cfg))
+
+		role, err := client.RbacV1().Roles(cfg.namespace).Get(ctx, name, metav1.GetOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"secrets"},
+				ResourceNames: []string{"db-credentials"},
+				Verbs:         []string{"get", "update"},
+			},
+		}, role.Rules)
+
+		roleBinding, err := client.RbacV1().RoleBindings(cfg.namespace).Get(ctx, name, metav1.GetOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, roleRef, roleBinding.RoleRef)
+		assert.Equal(t, []rbacv1.Subject{subject}, roleBinding.Subjects)
+	})
+
+	t.Run("merges the Secrets of other pods into the Role", func(t *testing.T) {
+		client := fake.NewClientset()
+		otherCfg := cfg
+		otherCfg.secretNames = []string{"api-key"}
+
+		assert.NoError(t, ensureSecretsProviderRBAC(ctx, client, cfg))
+		assert.NoError(t, ensureSecretsProviderRBAC(ctx, client, otherCfg))
+
+		role, err := client.RbacV1().Roles(cfg.namespace).Get(ctx, name, metav1.GetOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		if assert.Len(t, role.Rules, 1) {
+			assert.Equal(t, []string{"api-key", "db-credentials"}, role.Rules[0].ResourceNames)
+		}
+	})
+
+	t.Run("keeps existing RBAC granting the Secrets", func(t *testing.T) {
+		client := fake.NewClientset(
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cfg.namespace},
+				Rules:      []rbacv1.PolicyRule{secretsProviderPolicyRule([]string{"db-credentials"})},
+			},
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cfg.namespace},
+				RoleRef:    roleRef,
+				Subjects:   []rbacv1.Subject{subject},
+			},
+		)
+
+		assert.NoError(t, ensureSecretsProviderRBAC(ctx, client, cfg))
+
+		for _, action := range client.Actions() {
+			assert.Equal(t, "get", action.GetVerb())
+		}
+	})
+
+	t.Run("adds the service account to an existing RoleBinding", func(t *testing.T) {
+		other := rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "other-sa", Namespace: "app-ns"}
+		client := fake.NewClientset(&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cfg.namespace},
+			RoleRef:    roleRef,
+			Subjects:   []rbacv1.Subject{other},
+		})
+
+		assert.NoError(t, ensureSecretsProviderRBAC(ctx, client, cfg))
+
+		roleBinding, err := client.RbacV1().RoleBindings(cfg.namespace).Get(ctx, name, metav1.GetOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []rbacv1.Subject{other, subject}, roleBinding.Subjects)
+	})
+
+	t.Run("fails on a RoleBinding of another role", func(t *testing.T) {
+		client := fake.NewClientset(&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cfg.namespace},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     "view",
+			},
+			Subjects: []rbacv1.Subject{subject},
+		})
+
+		err := ensureSecretsProviderRBAC(ctx, client, cfg)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "RoleBinding app-ns/app-sa-conjur-secrets-provider binds ClusterRole view instead of Role app-sa-conjur-secrets-provider")
+		}
+	})
+
+	t.Run("dry run does not write", func(t *testing.T) {
+		client := fake.NewClientset()
+		dryRunCfg := cfg
+		dryRunCfg.sideEffects = sideEffects{dryRun: true}
+
+		assert.NoError(t, ensureSecretsProviderRBAC(ctx, client, dryRunCfg))
+
+		for _, action := range client.Actions() {
+			assert.Equal(t, "get", action.GetVerb())
+		}
+	})
+
+	t.Run("fails without Secret names", func(t *testing.T) {
+		client := fake.NewClientset()
+		noSecretsCfg := cfg
+		noSecretsCfg.secretNames = nil
+
+		assert.Error(t, ensureSecretsProviderRBAC(ctx, client, noSecretsCfg))
+	})
+}
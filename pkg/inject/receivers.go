@@ -0,0 +1,124 @@
+package inject
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// getReceivers returns the names of the pod containers that receive the
+// volumes of the injected containers, read from `conjur.org/conjur-inject-volumes`
+// or its deprecated alias `conjur.org/conjur-token-receivers`. Warnings about
+// the use of the alias are returned along with the names.
+func getReceivers(pod *corev1.Pod) ([]string, []string, error) {
+	var warnings []string
+
+	value, err := getAnnotation(&pod.ObjectMeta, annotationConjurInjectVolumesKey)
+	if aliasValue, aliasErr := getAnnotation(&pod.ObjectMeta, annotationConjurTokenReceiversKey); aliasErr == nil {
+		if err == nil {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s is deprecated and ignored in favor of %s",
+				annotationConjurTokenReceiversKey,
+				annotationConjurInjectVolumesKey,
+			))
+		} else {
+			value = aliasValue
+			warnings = append(warnings, fmt.Sprintf(
+				"%s is deprecated, use %s instead",
+				annotationConjurTokenReceiversKey,
+				annotationConjurInjectVolumesKey,
+			))
+		}
+	}
+
+	var receivers []string
+	for _, name := range splitList(value) {
+		if name != "" {
+			receivers = append(receivers, name)
+		}
+	}
+
+	containerNames := podContainerNames(pod)
+	for _, name := range receivers {
+		if !containerNames[name] {
+			return nil, warnings, fmt.Errorf(
+				"%s refers to unknown container %s",
+				annotationConjurInjectVolumesKey,
+				name,
+			)
+		}
+	}
+
+	return receivers, warnings, nil
+}
+
+// validateInitReceivers rejects init containers receiving the volumes of
+// injected containers that only start once the init containers are done, e.g.
+// sidecars, which would leave the volumes empty
+func validateInitReceivers(pod *corev1.Pod, receivers []string, sidecarConfig *PatchConfig) error {
+	if len(sidecarConfig.InitContainers) > 0 {
+		return nil
+	}
+
+	for _, name := range receivers {
+		for _, container := range pod.Spec.InitContainers {
+			if container.Name == name {
+				return fmt.Errorf(
+					"init container %s can't receive the volumes of sidecar containers, "+
+						"which start after it: use the init container mode or remove it from %s",
+					name,
+					annotationConjurInjectVolumesKey,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// podContainerNames returns the names of all the containers of the pod,
+// including init and ephemeral containers
+func podContainerNames(pod *corev1.Pod) map[string]bool {
+	names := map[string]bool{}
+	for _, container := range pod.Spec.InitContainers {
+		names[container.Name] = true
+	}
+	for _, container := range pod.Spec.Containers {
+		names[container.Name] = true
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		names[container.Name] = true
+	}
+
+	return names
+}
+
+// appendWarnings adds the warnings that aren't already listed, keeping the
+// order in which they were first raised
+func appendWarnings(warnings []string, added ...string) []string {
+	for _, warning := range added {
+		found := false
+		for _, existing := range warnings {
+			if existing == warning {
+				found = true
+				break
+			}
+		}
+		if !found {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	return warnings
+}
+
+// ephemeralContainersAsContainers returns the ephemeral containers of a pod
+// as containers, to patch their volume mounts like those of other containers
+func ephemeralContainersAsContainers(ephemeralContainers []corev1.EphemeralContainer) []corev1.Container {
+	var containers []corev1.Container
+	for _, ephemeralContainer := range ephemeralContainers {
+		containers = append(containers, corev1.Container(ephemeralContainer.EphemeralContainerCommon))
+	}
+
+	return containers
+}
@@ -0,0 +1,161 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetReceivers(t *testing.T) {
+	spec := corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init"}},
+		Containers:     []corev1.Container{{Name: "app"}, {Name: "worker"}},
+	}
+
+	var testCases = []struct {
+		description string
+		annotations map[string]string
+		expected    []string
+		warnings    []string
+		errContains string
+	}{
+		{
+			description: "no receivers",
+			annotations: map[string]string{},
+		},
+		{
+			description: "receivers with empty entries",
+			annotations: map[string]string{
+				"conjur.org/conjur-inject-volumes": "app, ,worker,",
+			},
+			expected: []string{"app", "worker"},
+		},
+		{
+			description: "init container receiver",
+			annotations: map[string]string{
+				"conjur.org/conjur-inject-volumes": "init",
+			},
+			expected: []string{"init"},
+		},
+		{
+			description: "deprecated alias",
+			annotations: map[string]string{
+				"conjur.org/conjur-token-receivers": "app",
+			},
+			expected: []string{"app"},
+			warnings: []string{
+				"conjur.org/conjur-token-receivers is deprecated, use conjur.org/conjur-inject-volumes instead",
+			},
+		},
+		{
+			description: "both annotations",
+			annotations: map[string]string{
+				"conjur.org/conjur-inject-volumes":  "worker",
+				"conjur.org/conjur-token-receivers": "app",
+			},
+			expected: []string{"worker"},
+			warnings: []string{
+				"conjur.org/conjur-token-receivers is deprecated and ignored in favor of conjur.org/conjur-inject-volumes",
+			},
+		},
+		{
+			description: "unknown receiver",
+			annotations: map[string]string{
+				"conjur.org/conjur-inject-volumes": "app,typo",
+			},
+			errContains: "conjur.org/conjur-inject-volumes refers to unknown container typo",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       spec,
+			}
+
+			receivers, warnings, err := getReceivers(pod)
+			assert.Equal(t, tc.warnings, warnings)
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, receivers)
+		})
+	}
+}
+
+func TestInitContainerReceiver(t *testing.T) {
+	reviewRequestBytes, err := newTestAdmissionRequest("./testdata/init-receiver-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Sidecars start after the init containers, which can't receive their volumes
+	req, err := NewAdmissionRequest(reviewRequestBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	admissionRes := HandleAdmissionRequest(newTestSidecarInjectorConfig(), req)
+	if assert.NotNil(t, admissionRes.Result) {
+		assert.Contains(
+			t,
+			admissionRes.Result.Message,
+			"init container migrate can't receive the volumes of sidecar containers",
+		)
+	}
+
+	reviewRequestBytes, err = addTestAnnotations(reviewRequestBytes, map[string]string{
+		"conjur.org/container-mode": "init",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err = NewAdmissionRequest(reviewRequestBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	admissionRes = HandleAdmissionRequest(newTestSidecarInjectorConfig(), req)
+	if !assert.Nil(t, admissionRes.Result) {
+		return
+	}
+	assert.Equal(t, []string{
+		"conjur.org/conjur-token-receivers is deprecated, use conjur.org/conjur-inject-volumes instead",
+	}, admissionRes.Warnings)
+
+	mod, err := applyPatchToAdmissionRequest(reviewRequestBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+
+	// The injected init container runs before the init container receiving
+	// its volume, and after the others
+	var initContainerNames []string
+	for _, container := range pod.Spec.InitContainers {
+		initContainerNames = append(initContainerNames, container.Name)
+	}
+	if !assert.Equal(t, []string{"setup", "authenticator-name", "migrate"}, initContainerNames) {
+		return
+	}
+
+	tokenMount := corev1.VolumeMount{
+		Name:      "conjur-access-token",
+		ReadOnly:  true,
+		MountPath: "/run/conjur",
+	}
+	assert.Empty(t, pod.Spec.InitContainers[0].VolumeMounts)
+	assert.Equal(t, []corev1.VolumeMount{tokenMount}, pod.Spec.InitContainers[2].VolumeMounts)
+	assert.Contains(t, pod.Spec.Containers[1].VolumeMounts, tokenMount)
+	assert.NotContains(t, pod.Spec.Containers[0].VolumeMounts, tokenMount)
+	assert.NotContains(t, pod.Annotations, "conjur.org/conjur-token-receivers")
+}
@@ -0,0 +1,141 @@
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cyberark/sidecar-injector/pkg/version"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Redactions of the admission records
+const (
+	RedactEnv      = "env"      // Values of environment variables and conjur.org/env.* annotations
+	RedactUserInfo = "userinfo" // User and groups of the admission requests
+)
+
+// AdmissionRecord is a line of an admission record file: an AdmissionReview
+// received by the sidecar injector and the response, with the patch decoded
+type AdmissionRecord struct {
+	Time            time.Time                   `json:"time"`
+	InjectorVersion string                      `json:"injectorVersion"`
+	Redacted        []string                    `json:"redacted,omitempty"`
+	EnvPatterns     []string                    `json:"envPatterns,omitempty"`
+	Review          admissionv1.AdmissionReview `json:"review"`
+	Patch           []rfc6902PatchOperation     `json:"patch,omitempty"`
+}
+
+// AdmissionRecorder writes admission records as JSON lines, usually to a
+// RotatingFile
+type AdmissionRecorder struct {
+	Output   io.Writer // Destination of the records, written one line at a time
+	Redact   []string  // Redactions applied to the records, RedactEnv and RedactUserInfo
+	Redactor *Redactor // Variables masked by RedactEnv and hashing of users, all variables when nil
+}
+
+// ParseRedactions parses a comma-separated list of redactions
+func ParseRedactions(value string) ([]string, error) {
+	var redactions []string
+	for _, redaction := range strings.Split(value, ",") {
+		redaction = strings.TrimSpace(redaction)
+		switch redaction {
+		case "":
+			continue
+		case RedactEnv, RedactUserInfo:
+			redactions = append(redactions, redaction)
+		default:
+			return nil, fmt.Errorf(
+				"invalid redaction %q, expecting %s or %s",
+				redaction,
+				RedactEnv,
+				RedactUserInfo,
+			)
+		}
+	}
+
+	return redactions, nil
+}
+
+// Record appends the record of an admission request and its response to the
+// record file
+func (recorder *AdmissionRecorder) Record(
+	req *admissionv1.AdmissionRequest,
+	res *admissionv1.AdmissionResponse,
+) error {
+	redactor := recorder.Redactor
+	if redactor == nil {
+		redactor = defaultRedactor
+	}
+	record, err := newAdmissionRecord(req, res, recorder.Redact, redactor)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = recorder.Output.Write(append(line, '\n'))
+	return err
+}
+
+// newAdmissionRecord returns the redacted record of an admission request and
+// its response
+func newAdmissionRecord(
+	req *admissionv1.AdmissionRequest,
+	res *admissionv1.AdmissionResponse,
+	redactions []string,
+	redactor *Redactor,
+) (*AdmissionRecord, error) {
+	request := req.DeepCopy()
+	request.UserInfo = redactor.userInfo(request.UserInfo)
+	response := res.DeepCopy()
+
+	var patch []rfc6902PatchOperation
+	if len(response.Patch) > 0 {
+		if err := json.Unmarshal(response.Patch, &patch); err != nil {
+			return nil, err
+		}
+		response.Patch = nil
+		response.PatchType = nil
+	}
+
+	var envPatterns []string
+	for _, redaction := range redactions {
+		switch redaction {
+		case RedactEnv:
+			var err error
+			if request.Object.Raw, err = redactor.object(request.Object.Raw); err != nil {
+				return nil, err
+			}
+			if request.OldObject.Raw, err = redactor.object(request.OldObject.Raw); err != nil {
+				return nil, err
+			}
+			patch = redactor.patchOperations(patch, false)
+			envPatterns = redactor.EnvPatterns
+		case RedactUserInfo:
+			request.UserInfo = authenticationv1.UserInfo{Username: redactedValue}
+		}
+	}
+
+	return &AdmissionRecord{
+		Time:            now().UTC(),
+		InjectorVersion: version.FullVersionName,
+		Redacted:        redactions,
+		EnvPatterns:     envPatterns,
+		Review: admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "admission.k8s.io/v1",
+				Kind:       "AdmissionReview",
+			},
+			Request:  request,
+			Response: response,
+		},
+		Patch: patch,
+	}, nil
+}
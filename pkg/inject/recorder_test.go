@@ -0,0 +1,184 @@
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// newTestRecordedAdmission runs an Admission Request with an env annotation
+// through the sidecar-injector logic
+func newTestRecordedAdmission(
+	sidecarInjectorConfig SidecarInjectorConfig,
+) (*admissionv1.AdmissionRequest, *admissionv1.AdmissionResponse, error) {
+	reqJSON, err := newTestAdmissionRequest("./testdata/authenticator-annotated-pod.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	reqJSON, err = addTestAnnotations(reqJSON, map[string]string{
+		"conjur.org/env.LOG_LEVEL": "debug",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := NewAdmissionRequest(reqJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := HandleAdmissionRequest(sidecarInjectorConfig, req)
+	return req, &res, nil
+}
+
+// readTestAdmissionRecords reads the records written by an AdmissionRecorder
+func readTestAdmissionRecords(content []byte) ([]AdmissionRecord, error) {
+	var records []AdmissionRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var record AdmissionRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func TestParseRedactions(t *testing.T) {
+	redactions, err := ParseRedactions("env, userinfo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{RedactEnv, RedactUserInfo}, redactions)
+
+	redactions, err = ParseRedactions("")
+	assert.NoError(t, err)
+	assert.Empty(t, redactions)
+
+	_, err = ParseRedactions("env,secrets")
+	assert.EqualError(t, err, `invalid redaction "secrets", expecting env or userinfo`)
+}
+
+func TestAdmissionRecorderRedaction(t *testing.T) {
+	var testCases = []struct {
+		description string
+		redact      []string
+		envValue    string
+		username    string
+	}{
+		{
+			description: "no redaction",
+			envValue:    "debug",
+			username:    "system:serviceaccount:kube-system:replicaset-controller",
+		},
+		{
+			description: "env values and user info",
+			redact:      []string{RedactEnv, RedactUserInfo},
+			envValue:    redactedValue,
+			username:    redactedValue,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req, res, err := newTestRecordedAdmission(newTestSidecarInjectorConfig())
+			if !assert.NoError(t, err) {
+				return
+			}
+			var output bytes.Buffer
+			recorder := &AdmissionRecorder{Output: &output, Redact: tc.redact}
+			if !assert.NoError(t, recorder.Record(req, res)) {
+				return
+			}
+
+			records, err := readTestAdmissionRecords(output.Bytes())
+			if !assert.NoError(t, err) || !assert.Len(t, records, 1) {
+				return
+			}
+			record := records[0]
+			assert.Equal(t, testInjectionTime, record.Time)
+			assert.Equal(t, tc.redact, record.Redacted)
+			assert.Equal(t, tc.username, record.Review.Request.UserInfo.Username)
+			assert.Nil(t, record.Review.Response.Patch)
+
+			var pod struct {
+				Metadata struct {
+					Annotations map[string]string `json:"annotations"`
+				} `json:"metadata"`
+			}
+			if !assert.NoError(t, json.Unmarshal(record.Review.Request.Object.Raw, &pod)) {
+				return
+			}
+			assert.Equal(t, tc.envValue, pod.Metadata.Annotations["conjur.org/env.LOG_LEVEL"])
+
+			// The variable set by the annotation is redacted in the patch too
+			patchJSON, err := json.Marshal(record.Patch)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Contains(t, string(patchJSON), `{"name":"LOG_LEVEL","value":"`+tc.envValue+`"}`)
+		})
+	}
+}
+
+func TestReplayAdmissionRecords(t *testing.T) {
+	req, res, err := newTestRecordedAdmission(newTestSidecarInjectorConfig())
+	if !assert.NoError(t, err) {
+		return
+	}
+	var output bytes.Buffer
+	recorder := &AdmissionRecorder{
+		Output: &output,
+		Redact: []string{RedactEnv, RedactUserInfo},
+	}
+	if !assert.NoError(t, recorder.Record(req, res)) {
+		return
+	}
+	// Records without a request are skipped
+	records := append(output.Bytes(), []byte("{\"review\": {}}\n")...)
+
+	upgraded := newTestSidecarInjectorConfig()
+	upgraded.AuthenticatorContainerImage = "authenticator-image:2"
+
+	var testCases = []struct {
+		description string
+		config      SidecarInjectorConfig
+		expected    ReplaySummary
+		output      []string
+	}{
+		{
+			description: "same configuration",
+			config:      newTestSidecarInjectorConfig(),
+			expected:    ReplaySummary{Matched: 1, Skipped: 1},
+		},
+		{
+			description: "other sidecar image",
+			config:      upgraded,
+			expected:    ReplaySummary{Mismatched: 1, Skipped: 1},
+			output: []string{
+				"Admission record 1, UID 0df28fbd-5f5f-11e8-bc74-36e6bb280816, Pod dummy/",
+				`patched pod: {"op":"replace","path":"/metadata/annotations/conjur.org~1injected-images","value":"authenticator=authenticator-image:2"}`,
+				`patched pod: {"op":"replace","path":"/spec/containers/2/image","value":"authenticator-image:2"}`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var out bytes.Buffer
+			summary, err := ReplayAdmissionRecords(tc.config, bytes.NewReader(records), &out)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, summary)
+			for _, line := range tc.output {
+				assert.Contains(t, out.String(), line)
+			}
+			if tc.output == nil {
+				assert.Empty(t, out.String())
+			}
+		})
+	}
+
+	_, err = ReplayAdmissionRecords(newTestSidecarInjectorConfig(), strings.NewReader("{"), &bytes.Buffer{})
+	assert.EqualError(t, err, "invalid admission record 1: unexpected EOF")
+}
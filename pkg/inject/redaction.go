@@ -0,0 +1,198 @@
+package inject
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// redactedValue replaces the redacted values in logs and records
+const redactedValue = "REDACTED"
+
+var (
+	// Paths of environment variables, in pods and patches
+	envVarPath = regexp.MustCompile(`/env/(\d+|-)$`)
+	// Paths of environment variable values whose variable isn't known, in patches
+	envValuePath = regexp.MustCompile(`/env/(\d+|-)/value$`)
+	// Paths of the annotations setting environment variable values
+	envAnnotationPath = regexp.MustCompile(
+		`/metadata/annotations/` + regexp.QuoteMeta(escapeJSONPointer(annotationEnvPrefix)) + `([^/]+)$`,
+	)
+	// PEM blocks, possibly cut
+	pemBlock = regexp.MustCompile(`-----BEGIN ([A-Z0-9 ]+)-----[^-]*(-----END [A-Z0-9 ]+-----)?`)
+)
+
+// Redactor masks the sensitive values of the logs and records of the sidecar
+// injector: the values of the environment variables whose name matches a
+// pattern, certificates and, optionally, the identity of the users
+type Redactor struct {
+	EnvPatterns  []string // Patterns of the names of the variables whose values are masked, as for path.Match
+	HashUserInfo bool     // Replace the usernames and UIDs of users with their hash
+}
+
+// defaultRedactor masks the values of every environment variable
+var defaultRedactor = &Redactor{EnvPatterns: []string{"*"}}
+
+// redactor returns the redaction of the logs
+func (config SidecarInjectorConfig) redactor() *Redactor {
+	if config.Redactor == nil {
+		return defaultRedactor
+	}
+	return config.Redactor
+}
+
+// ParseEnvPatterns parses a comma-separated list of environment variable name
+// patterns
+func ParseEnvPatterns(value string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid environment variable pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+// masksEnv returns whether the value of an environment variable is masked
+func (redactor *Redactor) masksEnv(name string) bool {
+	for _, pattern := range redactor.EnvPatterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// userInfo returns user info with the username and UID hashed, when enabled.
+// Extra values, which can identify the user, are dropped.
+func (redactor *Redactor) userInfo(userInfo authenticationv1.UserInfo) authenticationv1.UserInfo {
+	if !redactor.HashUserInfo {
+		return userInfo
+	}
+
+	return authenticationv1.UserInfo{
+		Username: hashIdentity(userInfo.Username),
+		UID:      hashIdentity(userInfo.UID),
+		Groups:   userInfo.Groups,
+	}
+}
+
+// patch returns a JSON patch indented for logs, with its environment variable
+// values masked and its certificates truncated
+func (redactor *Redactor) patch(patchBytes []byte) string {
+	var patch []rfc6902PatchOperation
+	if err := json.Unmarshal(patchBytes, &patch); err != nil {
+		return redactedValue
+	}
+	redacted, err := json.Marshal(redactor.patchOperations(patch, true))
+	if err != nil {
+		return redactedValue
+	}
+
+	var prettyJSON bytes.Buffer
+	if err := json.Indent(&prettyJSON, redacted, "", "\t"); err != nil {
+		return string(redacted)
+	}
+	return prettyJSON.String()
+}
+
+// patchOperations masks the environment variable values set by patch
+// operations, and truncates their certificates when required
+func (redactor *Redactor) patchOperations(
+	patch []rfc6902PatchOperation,
+	truncateCertificates bool,
+) []rfc6902PatchOperation {
+	redacted := make([]rfc6902PatchOperation, len(patch))
+	for i, operation := range patch {
+		if operation.Value != nil {
+			operation.Value = redactor.value(operation.Path, operation.Value, truncateCertificates)
+		}
+		redacted[i] = operation
+	}
+
+	return redacted
+}
+
+// object masks the environment variable values of an object in JSON
+func (redactor *Redactor) object(raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		return raw, nil
+	}
+
+	var object interface{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactor.value("", object, false))
+}
+
+// value masks the environment variable values under a JSON value decoded into
+// interface{} at a path, and truncates its certificates when required
+func (redactor *Redactor) value(path string, value interface{}, truncateCertificates bool) interface{} {
+	switch typed := value.(type) {
+	case string:
+		if envValuePath.MatchString(path) && len(redactor.EnvPatterns) > 0 {
+			return redactedValue
+		}
+		if match := envAnnotationPath.FindStringSubmatch(path); match != nil && redactor.masksEnv(match[1]) {
+			return redactedValue
+		}
+		if truncateCertificates {
+			return truncatePEMBlocks(typed)
+		}
+	case map[string]interface{}:
+		if name, ok := typed["name"].(string); ok && envVarPath.MatchString(path) && redactor.masksEnv(name) {
+			if _, ok := typed["value"].(string); ok {
+				typed["value"] = redactedValue
+			}
+		}
+		for key, child := range typed {
+			if key == "value" && envVarPath.MatchString(path) {
+				if truncateCertificates {
+					typed[key] = redactor.value("", child, truncateCertificates)
+				}
+				continue
+			}
+			typed[key] = redactor.value(path+"/"+escapeJSONPointer(key), child, truncateCertificates)
+		}
+	case []interface{}:
+		for i, child := range typed {
+			typed[i] = redactor.value(path+"/"+strconv.Itoa(i), child, truncateCertificates)
+		}
+	}
+
+	return value
+}
+
+// truncatePEMBlocks replaces the content of the PEM blocks of a string, e.g.
+// certificates, with its size
+func truncatePEMBlocks(value string) string {
+	return pemBlock.ReplaceAllStringFunc(value, func(block string) string {
+		match := pemBlock.FindStringSubmatch(block)
+		return fmt.Sprintf("-----BEGIN %s----- [%d bytes truncated]", match[1], len(block))
+	})
+}
+
+// hashIdentity returns the hash of a user identity, which identifies the user
+// without revealing it
+func hashIdentity(identity string) string {
+	if identity == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(identity))
+	return "sha256:" + hex.EncodeToString(sum[:])[:16]
+}
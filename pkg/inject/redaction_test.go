@@ -0,0 +1,232 @@
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const testCertificate = `-----BEGIN CERTIFICATE-----
+MIIDazCCAlOgAwIBAgIUUvUZ4uW8a6r1lqnX1Hq0kRG3g6gwDQYJKoZIhvcNAQEL
+-----END CERTIFICATE-----
+`
+
+// captureTestLogs returns what a function logs
+func captureTestLogs(f func()) string {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	f()
+	return output.String()
+}
+
+func TestParseEnvPatterns(t *testing.T) {
+	patterns, err := ParseEnvPatterns("CONJUR_*, *PASSWORD*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"CONJUR_*", "*PASSWORD*"}, patterns)
+
+	_, err = ParseEnvPatterns("CONJUR_[")
+	assert.EqualError(t, err, `invalid environment variable pattern "CONJUR_[": syntax error in pattern`)
+}
+
+func TestRedactorPatch(t *testing.T) {
+	patch := []rfc6902PatchOperation{
+		{
+			Op:   patchOperationAdd,
+			Path: "/spec/containers/-",
+			Value: corev1.Container{
+				Name: "authenticator",
+				Env: []corev1.EnvVar{
+					{Name: "CONJUR_SSL_CERTIFICATE", Value: testCertificate},
+					{Name: "LOG_LEVEL", Value: "debug"},
+					{Name: "CONJUR_AUTHN_URL", ValueFrom: &corev1.EnvVarSource{
+						ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "url"},
+					}},
+				},
+			},
+		},
+		{
+			Op:    patchOperationAdd,
+			Path:  "/spec/containers/0/env/-",
+			Value: corev1.EnvVar{Name: "DB_PASSWORD", Value: "app-password"},
+		},
+		{
+			Op:    patchOperationReplace,
+			Path:  "/spec/containers/0/env/1/value",
+			Value: "unknown-variable",
+		},
+		{
+			Op:    patchOperationAdd,
+			Path:  "/metadata/annotations/conjur.org~1env.LOG_LEVEL",
+			Value: "debug",
+		},
+		{
+			Op:    patchOperationAdd,
+			Path:  "/metadata/annotations/ca-bundle",
+			Value: testCertificate,
+		},
+	}
+	patchBytes, err := json.Marshal(patch)
+	if !assert.NoError(t, err) {
+		return
+	}
+	truncated := "-----BEGIN CERTIFICATE----- [118 bytes truncated]\n"
+
+	var testCases = []struct {
+		description string
+		redactor    *Redactor
+		values      map[string]string
+	}{
+		{
+			description: "default",
+			redactor:    defaultRedactor,
+			values: map[string]string{
+				"CONJUR_SSL_CERTIFICATE": redactedValue,
+				"LOG_LEVEL":              redactedValue,
+				"DB_PASSWORD":            redactedValue,
+				"replaced":               redactedValue,
+				"annotation":             redactedValue,
+				"ca-bundle":              truncated,
+			},
+		},
+		{
+			description: "patterns",
+			redactor:    &Redactor{EnvPatterns: []string{"CONJUR_*", "*PASSWORD*"}},
+			values: map[string]string{
+				"CONJUR_SSL_CERTIFICATE": redactedValue,
+				"LOG_LEVEL":              "debug",
+				"DB_PASSWORD":            redactedValue,
+				"replaced":               redactedValue,
+				"annotation":             "debug",
+				"ca-bundle":              truncated,
+			},
+		},
+		{
+			description: "no patterns",
+			redactor:    &Redactor{},
+			values: map[string]string{
+				"CONJUR_SSL_CERTIFICATE": truncated,
+				"LOG_LEVEL":              "debug",
+				"DB_PASSWORD":            "app-password",
+				"replaced":               "unknown-variable",
+				"annotation":             "debug",
+				"ca-bundle":              truncated,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var redacted []struct {
+				Value json.RawMessage `json:"value"`
+			}
+			if !assert.NoError(t, json.Unmarshal([]byte(tc.redactor.patch(patchBytes)), &redacted)) {
+				return
+			}
+			var container corev1.Container
+			var envVar corev1.EnvVar
+			var replaced, annotation, caBundle string
+			for i, target := range []interface{}{&container, &envVar, &replaced, &annotation, &caBundle} {
+				if !assert.NoError(t, json.Unmarshal(redacted[i].Value, target)) {
+					return
+				}
+			}
+
+			assert.Equal(t, tc.values, map[string]string{
+				"CONJUR_SSL_CERTIFICATE": container.Env[0].Value,
+				"LOG_LEVEL":              container.Env[1].Value,
+				"DB_PASSWORD":            envVar.Value,
+				"replaced":               replaced,
+				"annotation":             annotation,
+				"ca-bundle":              caBundle,
+			})
+			assert.Equal(t, "url", container.Env[2].ValueFrom.ConfigMapKeyRef.Key)
+		})
+	}
+}
+
+func TestRedactorUserInfo(t *testing.T) {
+	userInfo := authenticationv1.UserInfo{
+		Username: "jane",
+		UID:      "a7e0ab33-5f29-11e8-8a3c-36e6bb280816",
+		Groups:   []string{"system:authenticated"},
+		Extra:    map[string]authenticationv1.ExtraValue{"scopes": {"admin"}},
+	}
+
+	assert.Equal(t, userInfo, defaultRedactor.userInfo(userInfo))
+	assert.Regexp(t, "^sha256:[0-9a-f]{16}$", hashIdentity("jane"))
+	assert.Equal(
+		t,
+		authenticationv1.UserInfo{
+			Username: hashIdentity("jane"),
+			UID:      hashIdentity("a7e0ab33-5f29-11e8-8a3c-36e6bb280816"),
+			Groups:   []string{"system:authenticated"},
+		},
+		(&Redactor{HashUserInfo: true}).userInfo(userInfo),
+	)
+}
+
+// TestNoSecretsLogged checks that the logs of an injection don't contain the
+// values of environment variables nor, when hashed, the requesting user
+func TestNoSecretsLogged(t *testing.T) {
+	secrets := []string{
+		"MIIDazCCAlOgAwIBAgIUUvUZ4uW8a6r1lqnX1Hq0kRG3g6gwDQYJKoZIhvcNAQEL",
+		"annotation-secret",
+		"app-password",
+		"system:serviceaccount:kube-system:replicaset-controller",
+		"a7e0ab33-5f29-11e8-8a3c-36e6bb280816",
+	}
+
+	reqJSON, err := newTestAdmissionRequest("./testdata/authenticator-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	reqJSON, err = addTestAnnotations(reqJSON, map[string]string{
+		"conjur.org/env.API_KEY": "annotation-secret",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err := NewAdmissionRequest(reqJSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(req.Object.Raw, &pod)) {
+		return
+	}
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "DB_PASSWORD", Value: "app-password"}}
+	if req.Object.Raw, err = json.Marshal(pod); !assert.NoError(t, err) {
+		return
+	}
+
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.ConfigFile.Env.Defaults = []corev1.EnvVar{
+		{Name: "CONJUR_SSL_CERTIFICATE", Value: testCertificate},
+	}
+	sidecarInjectorConfig.Redactor = &Redactor{
+		EnvPatterns:  defaultRedactor.EnvPatterns,
+		HashUserInfo: true,
+	}
+
+	var res admissionv1.AdmissionResponse
+	logs := captureTestLogs(func() {
+		res = HandleAdmissionRequest(sidecarInjectorConfig, req)
+	})
+	if !assert.Nil(t, res.Result) || !assert.Contains(t, string(res.Patch), "annotation-secret") {
+		return
+	}
+	assert.Contains(t, logs, "AdmissionResponse: patch=")
+	for _, secret := range secrets {
+		assert.False(t, strings.Contains(logs, secret), "logs contain %q", secret)
+	}
+}
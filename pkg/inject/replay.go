@@ -0,0 +1,217 @@
+package inject
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Annotations that differ on every injection, ignored when comparing patches
+var volatileAnnotations = []string{
+	annotationInjectorVersionKey,
+	annotationInjectedAtKey,
+}
+
+// ReplaySummary counts the admission records replayed by ReplayAdmissionRecords
+type ReplaySummary struct {
+	Matched    int // Records whose response is produced again
+	Mismatched int // Records whose response differs
+	Skipped    int // Records without an admission request
+}
+
+// ReplayAdmissionRecords runs the admission requests of a JSONL admission
+// record file through HandleAdmissionRequest, as dry runs, and compares the
+// responses with the recorded ones. The differences are written to out.
+func ReplayAdmissionRecords(
+	sidecarInjectorConfig SidecarInjectorConfig,
+	records io.Reader,
+	out io.Writer,
+) (ReplaySummary, error) {
+	var summary ReplaySummary
+
+	decoder := json.NewDecoder(records)
+	for line := 1; ; line++ {
+		var record AdmissionRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			return summary, nil
+		} else if err != nil {
+			return summary, fmt.Errorf("invalid admission record %d: %v", line, err)
+		}
+		req := record.Review.Request
+		if req == nil {
+			summary.Skipped++
+			continue
+		}
+
+		differences, err := replayAdmissionRecord(sidecarInjectorConfig, &record)
+		if err != nil {
+			return summary, fmt.Errorf("could not replay admission record %d: %v", line, err)
+		}
+		if len(differences) == 0 {
+			summary.Matched++
+			continue
+		}
+
+		summary.Mismatched++
+		fmt.Fprintf(
+			out,
+			"Admission record %d, UID %s, %s %s/%s, recorded by v%s at %s:\n",
+			line,
+			req.UID,
+			req.Kind.Kind,
+			req.Namespace,
+			req.Name,
+			record.InjectorVersion,
+			record.Time.Format(time.RFC3339),
+		)
+		for _, difference := range differences {
+			fmt.Fprintf(out, "  %s\n", difference)
+		}
+	}
+}
+
+// replayAdmissionRecord replays an admission record and returns the
+// differences between the recorded and the produced response
+func replayAdmissionRecord(
+	sidecarInjectorConfig SidecarInjectorConfig,
+	record *AdmissionRecord,
+) ([]string, error) {
+	req := record.Review.Request.DeepCopy()
+	dryRun := true
+	req.DryRun = &dryRun
+
+	res := HandleAdmissionRequest(sidecarInjectorConfig, req)
+	var patch []rfc6902PatchOperation
+	if len(res.Patch) > 0 {
+		if err := json.Unmarshal(res.Patch, &patch); err != nil {
+			return nil, err
+		}
+	}
+	ignored := volatileAnnotations
+	if slices.Contains(record.Redacted, RedactEnv) {
+		// The configuration hash covers the redacted values
+		redactor := &Redactor{EnvPatterns: record.EnvPatterns}
+		patch = redactor.patchOperations(patch, false)
+		ignored = append(slices.Clone(ignored), annotationInjectedConfigKey)
+	}
+
+	var differences []string
+	recorded := record.Review.Response
+	if recorded == nil {
+		recorded = &admissionv1.AdmissionResponse{}
+	}
+	if recorded.Allowed != res.Allowed {
+		differences = append(differences, fmt.Sprintf("allowed: recorded %t, replayed %t", recorded.Allowed, res.Allowed))
+	}
+	if message, replayedMessage := resultMessage(recorded), resultMessage(&res); message != replayedMessage {
+		differences = append(differences, fmt.Sprintf("result: recorded %q, replayed %q", message, replayedMessage))
+	}
+	if !slices.Equal(recorded.Warnings, res.Warnings) {
+		differences = append(differences, fmt.Sprintf("warnings: recorded %q, replayed %q", recorded.Warnings, res.Warnings))
+	}
+
+	recordedPatch, err := toJSONValue(withoutAnnotations(record.Patch, ignored))
+	if err != nil {
+		return nil, err
+	}
+	replayedPatch, err := toJSONValue(withoutAnnotations(patch, ignored))
+	if err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(recordedPatch, replayedPatch) {
+		return differences, nil
+	}
+
+	// The patches are compared by their result, which is clearer than their
+	// operations
+	recordedPod, recordedErr := applyRecordedPatch(req.Object.Raw, recordedPatch)
+	replayedPod, replayedErr := applyRecordedPatch(req.Object.Raw, replayedPatch)
+	if err := errors.Join(recordedErr, replayedErr); err != nil {
+		recordedJSON, _ := json.Marshal(recordedPatch)
+		replayedJSON, _ := json.Marshal(replayedPatch)
+		return append(
+			differences,
+			fmt.Sprintf("patch: recorded %s", recordedJSON),
+			fmt.Sprintf("patch: replayed %s", replayedJSON),
+		), nil
+	}
+	operations := diffJSON("", recordedPod, replayedPod)
+	if len(operations) == 0 {
+		return append(differences, "patch: same result with other operations"), nil
+	}
+	for _, operation := range operations {
+		operationJSON, err := json.Marshal(operation)
+		if err != nil {
+			return nil, err
+		}
+		differences = append(differences, fmt.Sprintf("patched pod: %s", operationJSON))
+	}
+
+	return differences, nil
+}
+
+// withoutAnnotations returns a patch without the operations setting some
+// annotations
+func withoutAnnotations(patch []rfc6902PatchOperation, keys []string) []rfc6902PatchOperation {
+	var filtered []rfc6902PatchOperation
+	for _, operation := range patch {
+		skipped := false
+		for _, key := range keys {
+			if operation.Path == "/metadata/annotations/"+escapeJSONPointer(key) {
+				skipped = true
+			}
+		}
+		if annotations, ok := operation.Value.(map[string]interface{}); ok && operation.Path == "/metadata/annotations" {
+			kept := map[string]interface{}{}
+			for key, value := range annotations {
+				if !slices.Contains(keys, key) {
+					kept[key] = value
+				}
+			}
+			operation.Value = kept
+		}
+		if !skipped {
+			filtered = append(filtered, operation)
+		}
+	}
+
+	return filtered
+}
+
+// applyRecordedPatch applies a patch decoded into interface{} to an object in
+// JSON, and returns the result decoded into interface{}
+func applyRecordedPatch(object []byte, patch interface{}) (interface{}, error) {
+	patchJSON, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	if patch == nil {
+		patchJSON = []byte("[]")
+	}
+	decoded, err := jsonpatch.DecodePatch(patchJSON)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := decoded.Apply(object)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	err = json.Unmarshal(patched, &result)
+	return result, err
+}
+
+func resultMessage(res *admissionv1.AdmissionResponse) string {
+	if res.Result == nil {
+		return ""
+	}
+	return res.Result.Message
+}
@@ -0,0 +1,96 @@
+package inject
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile is an append-only file for records written one at a time, rotated
+// when it would grow beyond MaxSize with MaxBackups previous files kept as
+// <path>.1, <path>.2... It is safe for concurrent use.
+type RotatingFile struct {
+	Path       string // Path of the file
+	MaxSize    int64  // Size in bytes above which the file is rotated
+	MaxBackups int    // Number of rotated files kept
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// Write appends a record to the file, after rotating it when the record would
+// make it grow beyond MaxSize. A record is never split across files.
+func (f *RotatingFile) Write(record []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.size > 0 && f.size+int64(len(record)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(record)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate closes the file and shifts it and the previous files by one,
+// dropping the oldest
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.MaxBackups < 1 {
+		return os.Remove(f.Path)
+	}
+	backup := func(i int) string {
+		return f.Path + "." + strconv.Itoa(i)
+	}
+	for i := f.MaxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(f.Path, backup(1))
+}
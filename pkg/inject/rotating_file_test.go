@@ -0,0 +1,37 @@
+package inject
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	if !assert.NoError(t, os.WriteFile(path, []byte("0\n"), 0600)) {
+		return
+	}
+
+	file := &RotatingFile{Path: path, MaxSize: 4, MaxBackups: 2}
+	for _, record := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+		if _, err := file.Write([]byte(record)); !assert.NoError(t, err) {
+			return
+		}
+	}
+	assert.NoError(t, file.Close())
+
+	// Records are appended to the existing file, which rotates when a record
+	// would make it exceed the size, and the oldest backups are dropped
+	for suffix, expected := range map[string]string{
+		"":   "4\n5\n",
+		".1": "2\n3\n",
+		".2": "0\n1\n",
+	} {
+		content, err := os.ReadFile(path + suffix)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+	assert.NoFileExists(t, path+".3")
+}
@@ -110,22 +110,9 @@ func generateSecretlessSidecarConfig(cfg SecretlessSidecarConfig) *PatchConfig {
 	// #2 Can't be passed straight through to the broker as its
 	// expecting configfile#fspath
 
-	if strings.Contains(cfg.secretlessConfig, "#") {
-		// configmgr#configspec
-		parts := strings.Split(cfg.secretlessConfig, "#")
-		configMgr = parts[0]
-		configSpec = parts[1]
-
-		// option 2
-		if configMgr == "configfile" {
-			secretlessConfigMapName = configSpec
-			configSpec = secretlessConfigPath
-		}
-	} else {
-		// option 1
-		// Old format, contains config map name only.
-		configMgr = "configfile"
-		secretlessConfigMapName = cfg.secretlessConfig
+	configMgr, configSpec = parseSecretlessConfig(cfg.secretlessConfig)
+	if configMgr == "configfile" {
+		secretlessConfigMapName = configSpec
 		configSpec = secretlessConfigPath
 	}
 
@@ -173,3 +160,17 @@ func generateSecretlessSidecarConfig(cfg SecretlessSidecarConfig) *PatchConfig {
 		Volumes:    volumes,
 	}
 }
+
+// parseSecretlessConfig splits the `conjur.org/secretless-config` annotation
+// into the Secretless config manager and its spec. For the configfile manager,
+// the spec is the name of the ConfigMap holding the configuration.
+func parseSecretlessConfig(secretlessConfig string) (string, string) {
+	if strings.Contains(secretlessConfig, "#") {
+		// configmgr#configspec
+		parts := strings.Split(secretlessConfig, "#")
+		return parts[0], parts[1]
+	}
+
+	// Old format, contains config map name only.
+	return "configfile", secretlessConfig
+}
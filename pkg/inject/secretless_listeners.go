@@ -0,0 +1,381 @@
+package inject
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Key of the Secretless configuration in its ConfigMap, mounted by the
+// Secretless sidecar at /etc/secretless/secretless.yml
+const secretlessConfigMapKey = "secretless.yml"
+
+// secretlessListener is a service Secretless listens on for the application
+type secretlessListener struct {
+	name string
+	// Connector of a v2 service, or protocol of a v1 listener, e.g. pg
+	protocol string
+	// "tcp" or "unix"
+	network string
+	// host:port for tcp, socket path for unix
+	address string
+}
+
+// secretlessConfiguration holds the parts of a Secretless configuration,
+// version 2 or 1, that describe what it listens on
+type secretlessConfiguration struct {
+	Version   interface{}                  `json:"version"`
+	Services  map[string]secretlessService `json:"services"`
+	Listeners []secretlessV1Listener       `json:"listeners"`
+}
+
+type secretlessService struct {
+	Connector string `json:"connector"`
+	// Deprecated name of connector
+	Protocol string `json:"protocol"`
+	ListenOn string `json:"listenOn"`
+}
+
+type secretlessV1Listener struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Socket   string `json:"socket"`
+}
+
+// parseSecretlessListeners parses and validates a Secretless configuration,
+// returning the listeners sorted by name
+func parseSecretlessListeners(config secretlessConfiguration) ([]secretlessListener, error) {
+	var listeners []secretlessListener
+
+	version := ""
+	if config.Version != nil {
+		version = fmt.Sprint(config.Version)
+	}
+	switch version {
+	case "2":
+		if len(config.Services) == 0 {
+			return nil, fmt.Errorf("no services defined")
+		}
+		for name, service := range config.Services {
+			protocol := service.Connector
+			if protocol == "" {
+				protocol = service.Protocol
+			}
+			if protocol == "" {
+				return nil, fmt.Errorf("service %s has no connector", name)
+			}
+			network, address, err := parseListenOn(service.ListenOn)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %v", name, err)
+			}
+			listeners = append(listeners, secretlessListener{
+				name:     name,
+				protocol: protocol,
+				network:  network,
+				address:  address,
+			})
+		}
+	case "", "1":
+		if len(config.Listeners) == 0 {
+			return nil, fmt.Errorf("no listeners defined")
+		}
+		for _, listener := range config.Listeners {
+			if listener.Name == "" || listener.Protocol == "" {
+				return nil, fmt.Errorf("listeners must have a name and a protocol")
+			}
+			if (listener.Address == "") == (listener.Socket == "") {
+				return nil, fmt.Errorf("listener %s must have either an address or a socket", listener.Name)
+			}
+			listenOn := "unix://" + listener.Socket
+			if listener.Address != "" {
+				listenOn = "tcp://" + listener.Address
+			}
+			network, address, err := parseListenOn(listenOn)
+			if err != nil {
+				return nil, fmt.Errorf("listener %s: %v", listener.Name, err)
+			}
+			listeners = append(listeners, secretlessListener{
+				name:     listener.Name,
+				protocol: listener.Protocol,
+				network:  network,
+				address:  address,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported version %s", version)
+	}
+
+	sort.Slice(listeners, func(i, j int) bool {
+		return listeners[i].name < listeners[j].name
+	})
+
+	used := map[string]string{}
+	for _, listener := range listeners {
+		key := listener.network + "://" + listener.address
+		if listener.network == "tcp" {
+			_, port, _ := net.SplitHostPort(listener.address)
+			key = "tcp port " + port
+		}
+		if other, ok := used[key]; ok {
+			return nil, fmt.Errorf("%s and %s listen on the same %s", other, listener.name, key)
+		}
+		used[key] = listener.name
+	}
+
+	return listeners, nil
+}
+
+// parseListenOn parses a Secretless listen address, `tcp://<host>:<port>` or
+// `unix://<socket path>`
+func parseListenOn(listenOn string) (string, string, error) {
+	parts := strings.SplitN(listenOn, "://", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("listen address %q must start with tcp:// or unix://", listenOn)
+	}
+
+	switch parts[0] {
+	case "tcp":
+		_, portStr, err := net.SplitHostPort(parts[1])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid listen address %q: %v", listenOn, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return "", "", fmt.Errorf("invalid port in listen address %q", listenOn)
+		}
+	case "unix":
+		if !path.IsAbs(parts[1]) {
+			return "", "", fmt.Errorf("socket path in listen address %q must be absolute", listenOn)
+		}
+	default:
+		return "", "", fmt.Errorf("listen address %q must start with tcp:// or unix://", listenOn)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// secretlessConfigurationResource returns the Secretless configuration custom
+// resource, whose group depends on the CRD suffix
+func secretlessConfigurationResource(crdSuffix string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    fmt.Sprintf("secretless%s.io", crdSuffix),
+		Version:  "v1",
+		Resource: "configurations",
+	}
+}
+
+// getSecretlessConfiguration reads the Secretless configuration referenced by
+// the `conjur.org/secretless-config` annotation from the Kubernetes API
+func getSecretlessConfiguration(
+	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	namespace string,
+	secretlessConfig string,
+	crdSuffix string,
+) (secretlessConfiguration, error) {
+	var config secretlessConfiguration
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	configMgr, configSpec := parseSecretlessConfig(secretlessConfig)
+	switch configMgr {
+	case "configfile":
+		if kubeClient == nil {
+			return config, fmt.Errorf("no Kubernetes client to read Secretless ConfigMap %s", configSpec)
+		}
+		configMap, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, configSpec, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return config, fmt.Errorf("Secretless ConfigMap %s not found in namespace %s", configSpec, namespace)
+		}
+		if err != nil {
+			return config, fmt.Errorf("unable to read Secretless ConfigMap %s: %v", configSpec, err)
+		}
+		data, ok := configMap.Data[secretlessConfigMapKey]
+		if !ok {
+			return config, fmt.Errorf("Secretless ConfigMap %s has no %s key", configSpec, secretlessConfigMapKey)
+		}
+		if err := yaml.Unmarshal([]byte(data), &config); err != nil {
+			return config, fmt.Errorf("Secretless ConfigMap %s is not valid YAML: %v", configSpec, err)
+		}
+	case "k8s/crd":
+		if dynamicClient == nil {
+			return config, fmt.Errorf("no Kubernetes client to read Secretless configuration %s", configSpec)
+		}
+		resource := secretlessConfigurationResource(crdSuffix)
+		u, err := dynamicClient.Resource(resource).Namespace(namespace).Get(ctx, configSpec, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return config, fmt.Errorf("Secretless configuration %s not found in namespace %s", configSpec, namespace)
+		}
+		if err != nil {
+			return config, fmt.Errorf("unable to read Secretless configuration %s: %v", configSpec, err)
+		}
+		spec, err := json.Marshal(u.Object["spec"])
+		if err == nil {
+			err = json.Unmarshal(spec, &config)
+		}
+		if err != nil {
+			return config, fmt.Errorf("Secretless configuration %s has an invalid spec: %v", configSpec, err)
+		}
+	default:
+		return config, fmt.Errorf("Secretless config manager %s doesn't support listener discovery", configMgr)
+	}
+
+	return config, nil
+}
+
+// discoverSecretlessListeners reads and validates the Secretless
+// configuration of a pod
+func discoverSecretlessListeners(
+	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	namespace string,
+	secretlessConfig string,
+	crdSuffix string,
+) ([]secretlessListener, error) {
+	config, err := getSecretlessConfiguration(kubeClient, dynamicClient, namespace, secretlessConfig, crdSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners, err := parseSecretlessListeners(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Secretless configuration %s: %v", secretlessConfig, err)
+	}
+
+	return listeners, nil
+}
+
+var nonEnvVarNameChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// listenerEnvVarPrefix returns the prefix of the environment variables of a
+// listener, e.g. PG_DB for pg-db
+func listenerEnvVarPrefix(name string) string {
+	return nonEnvVarNameChars.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+// Socket file name of PostgreSQL, ending with the port
+var pgSocketName = regexp.MustCompile(`^\.s\.PGSQL\.(\d+)$`)
+
+// secretlessListenerEnvVars returns the environment variables pointing the
+// application to the Secretless listeners: `<NAME>_ADDR` or `<NAME>_SOCKET`
+// for every listener, and the variables of the standard clients for protocols
+// with a single listener, e.g. PGHOST and PGPORT
+func secretlessListenerEnvVars(listeners []secretlessListener) ([]corev1.EnvVar, error) {
+	var envVars []corev1.EnvVar
+	names := map[string]string{}
+	add := func(listener string, name string, value string) error {
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("listeners %s and %s both set %s", other, listener, name)
+		}
+		names[name] = listener
+		envVars = append(envVars, envVarFromLiteral(name, value))
+		return nil
+	}
+
+	byProtocol := map[string]int{}
+	for _, listener := range listeners {
+		byProtocol[listener.protocol]++
+	}
+
+	for _, listener := range listeners {
+		prefix := listenerEnvVarPrefix(listener.name)
+		host, port, socketDir := "localhost", "", ""
+		var err error
+		if listener.network == "tcp" {
+			_, port, _ = net.SplitHostPort(listener.address)
+			err = add(listener.name, prefix+"_ADDR", net.JoinHostPort(host, port))
+		} else {
+			socketDir = path.Dir(listener.address)
+			err = add(listener.name, prefix+"_SOCKET", listener.address)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if byProtocol[listener.protocol] != 1 {
+			continue
+		}
+
+		var clientEnvVars [][2]string
+		switch listener.protocol {
+		case "pg":
+			if listener.network == "unix" {
+				host = socketDir
+				if match := pgSocketName.FindStringSubmatch(path.Base(listener.address)); match != nil {
+					port = match[1]
+				}
+			}
+			clientEnvVars = append(clientEnvVars, [2]string{"PGHOST", host})
+			if port != "" {
+				clientEnvVars = append(clientEnvVars, [2]string{"PGPORT", port})
+			}
+		case "mysql":
+			if listener.network == "unix" {
+				clientEnvVars = append(clientEnvVars, [2]string{"MYSQL_UNIX_PORT", listener.address})
+			} else {
+				// The MySQL clients connect to "localhost" through a socket
+				clientEnvVars = append(clientEnvVars,
+					[2]string{"MYSQL_HOST", "127.0.0.1"},
+					[2]string{"MYSQL_TCP_PORT", port},
+				)
+			}
+		}
+		for _, envVar := range clientEnvVars {
+			if err := add(listener.name, envVar[0], envVar[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return envVars, nil
+}
+
+// secretlessListenerEnv returns the listener environment variables of the
+// application containers: those listed in `conjur.org/conjur-inject-volumes`,
+// or all of them when none is listed. Variables already set by a container are
+// left untouched.
+func secretlessListenerEnv(
+	pod *corev1.Pod,
+	receivers []string,
+	envVars []corev1.EnvVar,
+) map[string][]corev1.EnvVar {
+	isReceiver := map[string]bool{}
+	for _, name := range receivers {
+		isReceiver[name] = true
+	}
+
+	containerEnv := map[string][]corev1.EnvVar{}
+	for _, container := range pod.Spec.Containers {
+		if len(receivers) > 0 && !isReceiver[container.Name] {
+			continue
+		}
+
+		existing := map[string]bool{}
+		for _, envVar := range container.Env {
+			existing[envVar.Name] = true
+		}
+		for _, envVar := range envVars {
+			if !existing[envVar.Name] {
+				containerEnv[container.Name] = append(containerEnv[container.Name], envVar)
+			}
+		}
+	}
+
+	return containerEnv
+}
@@ -0,0 +1,339 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+)
+
+const testSecretlessConfig = `version: 2
+services:
+  pg-db:
+    connector: pg
+    listenOn: tcp://0.0.0.0:5432
+    credentials:
+      username: postgres
+  http_api:
+    connector: generic_http
+    listenOn: tcp://0.0.0.0:8080
+`
+
+func TestParseSecretlessListeners(t *testing.T) {
+	var testCases = []struct {
+		description string
+		config      string
+		expected    []secretlessListener
+		errContains string
+	}{
+		{
+			description: "version 2",
+			config:      testSecretlessConfig,
+			expected: []secretlessListener{
+				{name: "http_api", protocol: "generic_http", network: "tcp", address: "0.0.0.0:8080"},
+				{name: "pg-db", protocol: "pg", network: "tcp", address: "0.0.0.0:5432"},
+			},
+		},
+		{
+			description: "version 1",
+			config: `listeners:
+  - name: pg_socket
+    protocol: pg
+    socket: /sock/.s.PGSQL.5432
+handlers:
+  - name: pg_handler
+    listener: pg_socket
+`,
+			expected: []secretlessListener{
+				{name: "pg_socket", protocol: "pg", network: "unix", address: "/sock/.s.PGSQL.5432"},
+			},
+		},
+		{
+			description: "unsupported version",
+			config:      "version: 3\n",
+			errContains: "unsupported version 3",
+		},
+		{
+			description: "no services",
+			config:      "version: 2\nservices: {}\n",
+			errContains: "no services defined",
+		},
+		{
+			description: "missing connector",
+			config:      "version: 2\nservices:\n  db:\n    listenOn: tcp://0.0.0.0:5432\n",
+			errContains: "service db has no connector",
+		},
+		{
+			description: "invalid listen address",
+			config:      "version: 2\nservices:\n  db:\n    connector: pg\n    listenOn: 0.0.0.0:5432\n",
+			errContains: "must start with tcp:// or unix://",
+		},
+		{
+			description: "invalid port",
+			config:      "version: 2\nservices:\n  db:\n    connector: pg\n    listenOn: tcp://0.0.0.0:99999\n",
+			errContains: "invalid port",
+		},
+		{
+			description: "same port",
+			config: `version: 2
+services:
+  a:
+    connector: pg
+    listenOn: tcp://0.0.0.0:5432
+  b:
+    connector: mysql
+    listenOn: tcp://localhost:5432
+`,
+			errContains: "a and b listen on the same tcp port 5432",
+		},
+		{
+			description: "listener with address and socket",
+			config:      "listeners:\n  - name: db\n    protocol: pg\n    address: 0.0.0.0:5432\n    socket: /sock/pg\n",
+			errContains: "listener db must have either an address or a socket",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var config secretlessConfiguration
+			if !assert.NoError(t, yaml.Unmarshal([]byte(tc.config), &config)) {
+				return
+			}
+
+			listeners, err := parseSecretlessListeners(config)
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, listeners)
+		})
+	}
+}
+
+func TestSecretlessListenerEnvVars(t *testing.T) {
+	var testCases = []struct {
+		description string
+		listeners   []secretlessListener
+		expected    map[string]string
+		errContains string
+	}{
+		{
+			description: "single pg listener",
+			listeners: []secretlessListener{
+				{name: "http_api", protocol: "generic_http", network: "tcp", address: "0.0.0.0:8080"},
+				{name: "pg-db", protocol: "pg", network: "tcp", address: "0.0.0.0:5432"},
+			},
+			expected: map[string]string{
+				"HTTP_API_ADDR": "localhost:8080",
+				"PG_DB_ADDR":    "localhost:5432",
+				"PGHOST":        "localhost",
+				"PGPORT":        "5432",
+			},
+		},
+		{
+			description: "pg socket",
+			listeners: []secretlessListener{
+				{name: "pg", protocol: "pg", network: "unix", address: "/sock/.s.PGSQL.5433"},
+			},
+			expected: map[string]string{
+				"PG_SOCKET": "/sock/.s.PGSQL.5433",
+				"PGHOST":    "/sock",
+				"PGPORT":    "5433",
+			},
+		},
+		{
+			description: "mysql",
+			listeners: []secretlessListener{
+				{name: "mysql", protocol: "mysql", network: "tcp", address: "0.0.0.0:3306"},
+			},
+			expected: map[string]string{
+				"MYSQL_ADDR":     "localhost:3306",
+				"MYSQL_HOST":     "127.0.0.1",
+				"MYSQL_TCP_PORT": "3306",
+			},
+		},
+		{
+			description: "several pg listeners",
+			listeners: []secretlessListener{
+				{name: "orders", protocol: "pg", network: "tcp", address: "0.0.0.0:5432"},
+				{name: "users", protocol: "pg", network: "tcp", address: "0.0.0.0:5433"},
+			},
+			expected: map[string]string{
+				"ORDERS_ADDR": "localhost:5432",
+				"USERS_ADDR":  "localhost:5433",
+			},
+		},
+		{
+			description: "names mapping to the same variable",
+			listeners: []secretlessListener{
+				{name: "pg-db", protocol: "pg", network: "tcp", address: "0.0.0.0:5432"},
+				{name: "pg_db", protocol: "mysql", network: "tcp", address: "0.0.0.0:3306"},
+			},
+			errContains: "listeners pg-db and pg_db both set PG_DB_ADDR",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			envVars, err := secretlessListenerEnvVars(tc.listeners)
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			assert.NoError(t, err)
+
+			env := map[string]string{}
+			for _, envVar := range envVars {
+				env[envVar.Name] = envVar.Value
+			}
+			assert.Equal(t, tc.expected, env)
+		})
+	}
+}
+
+func TestSecretlessListenersInjection(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "secretless-config", Namespace: "dummy"},
+		Data:       map[string]string{"secretless.yml": testSecretlessConfig},
+	}
+
+	var testCases = []struct {
+		description string
+		objects     []runtime.Object
+		annotations map[string]string
+		disabled    bool
+		errContains string
+	}{
+		{
+			description: "env set in listed container",
+			objects:     []runtime.Object{configMap},
+			annotations: map[string]string{
+				"conjur.org/secretless-listener-env": "true",
+				"conjur.org/conjur-inject-volumes":   "nginx-2",
+			},
+		},
+		{
+			description: "config validated without env",
+			objects:     []runtime.Object{configMap},
+			annotations: map[string]string{},
+		},
+		{
+			description: "ConfigMap not found",
+			annotations: map[string]string{},
+			errContains: "Secretless ConfigMap secretless-config not found in namespace dummy",
+		},
+		{
+			description: "invalid config",
+			objects: []runtime.Object{&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "secretless-config", Namespace: "dummy"},
+				Data:       map[string]string{"secretless.yml": "version: 2\nservices:\n  db:\n    connector: pg\n"},
+			}},
+			annotations: map[string]string{},
+			errContains: "invalid Secretless configuration secretless-config: service db: listen address",
+		},
+		{
+			description: "discovery disabled",
+			annotations: map[string]string{"conjur.org/secretless-listener-env": "true"},
+			disabled:    true,
+			errContains: "conjur.org/secretless-listener-env requires the sidecar injector to run with -secretless-listeners",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			sidecarInjectorConfig := newTestSidecarInjectorConfig()
+			sidecarInjectorConfig.SecretlessListeners = !tc.disabled
+			sidecarInjectorConfig.KubeClient = fake.NewSimpleClientset(tc.objects...)
+
+			req, err := newTestAdmissionRequest("./testdata/secretless-annotated-pod.json")
+			if !assert.NoError(t, err) {
+				return
+			}
+			req, err = addTestAnnotations(req, tc.annotations)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var pod corev1.Pod
+			if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+				return
+			}
+			envs := map[string]map[string]string{}
+			for _, container := range pod.Spec.Containers {
+				envs[container.Name] = map[string]string{}
+				for _, envVar := range container.Env {
+					envs[container.Name][envVar.Name] = envVar.Value
+				}
+			}
+			assert.Empty(t, envs["nginx-1"])
+			if tc.annotations["conjur.org/secretless-listener-env"] == "true" {
+				assert.Equal(t, "localhost:5432", envs["nginx-2"]["PG_DB_ADDR"])
+				assert.Equal(t, "5432", envs["nginx-2"]["PGPORT"])
+			} else {
+				assert.Empty(t, envs["nginx-2"])
+			}
+			assert.NotContains(t, pod.Annotations, "conjur.org/secretless-listener-env")
+		})
+	}
+}
+
+func TestSecretlessListenersFromCRD(t *testing.T) {
+	configuration := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "secretless-test.io/v1",
+		"kind":       "Configuration",
+		"metadata": map[string]interface{}{
+			"name":      "secretless-crd",
+			"namespace": "dummy",
+		},
+		"spec": map[string]interface{}{
+			"listeners": []interface{}{
+				map[string]interface{}{
+					"name":     "mysql",
+					"protocol": "mysql",
+					"address":  "0.0.0.0:3306",
+				},
+			},
+		},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{secretlessConfigurationResource("-test"): "ConfigurationList"},
+		configuration,
+	)
+
+	listeners, err := discoverSecretlessListeners(nil, dynamicClient, "dummy", "k8s/crd#secretless-crd", "-test")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []secretlessListener{
+			{name: "mysql", protocol: "mysql", network: "tcp", address: "0.0.0.0:3306"},
+		}, listeners)
+	}
+
+	_, err = discoverSecretlessListeners(nil, dynamicClient, "dummy", "k8s/crd#missing", "-test")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Secretless configuration missing not found in namespace dummy")
+	}
+}
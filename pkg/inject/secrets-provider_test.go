@@ -1,10 +1,13 @@
 package inject
 
 import (
-	"github.com/stretchr/testify/assert"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func TestSecretsProviderSidecarInjection(t *testing.T) {
@@ -83,3 +86,36 @@ func TestSecretsProviderSidecarInjection(t *testing.T) {
 		})
 	}
 }
+
+// TestSecretsProviderK8sSecretsReceivers checks that the receivers of a
+// Secrets Provider writing to Kubernetes Secrets only mount its status, as
+// there is no secrets volume
+func TestSecretsProviderK8sSecretsReceivers(t *testing.T) {
+	req, err := newTestAdmissionRequest("./testdata/secrets-provider-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err = addTestAnnotations(req, map[string]string{
+		"conjur.org/secrets-destination": "k8s_secrets",
+		"conjur.org/k8s-secrets":         "- db-credentials",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequest(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+
+	assert.Equal(t, []corev1.VolumeMount{
+		{Name: "conjur-status", MountPath: "/conjur/status"},
+	}, pod.Spec.Containers[0].VolumeMounts[1:])
+	for _, volume := range pod.Spec.Volumes {
+		assert.NotEqual(t, "conjur-secrets", volume.Name)
+	}
+}
@@ -1,12 +1,13 @@
 package inject
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strings"
+	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
@@ -15,6 +16,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
 var (
@@ -40,19 +44,57 @@ var ignoredNamespaces = []string{
 }
 
 type WebhookServer struct {
-	Server *http.Server
-	Params WebhookServerParameters
+	Server     *http.Server
+	Params     WebhookServerParameters
+	KubeClient kubernetes.Interface // Client for the Kubernetes API, nil when not required
+
+	NamespaceLister corelisters.NamespaceLister // Cached namespaces, nil when not required
+
+	DynamicClient dynamic.Interface            // Client for custom resources, nil when not required
+	PolicyLister  SidecarInjectionPolicyLister // Cached SidecarInjectionPolicies, nil when not required
+	PolicyStatus  *PolicyStatusUpdater         // Updates the status of matched policies, nil when not required
+
+	ConfigFile ConfigFile // Settings loaded from the injector configuration file
+	Redactor   *Redactor  // Redaction of the logs and records, the default one when nil
+
+	Recorder    *AdmissionRecorder // Records the admission requests and responses, nil when not required
+	AuditLogger *AuditLogger       // Logs the admission decisions for auditing, nil when not required
+
+	EventRecorder *InjectionEventRecorder // Emits events for the injection outcomes of pods, nil when not required
 }
 
 // Webhook Server parameters
 type WebhookServerParameters struct {
-	NoHTTPS                       bool   // Runs an HTTP server when true
-	Port                          int    // Webhook Server port
-	CertFile                      string // Path to the x509 certificate for https
-	KeyFile                       string // Path to the x509 private key matching `CertFile`
-	SecretlessContainerImage      string // Container image for the Secretless sidecar
-	AuthenticatorContainerImage   string // Container image for the K8s Authenticator sidecar
-	SecretsProviderContainerImage string // Container image for the Secrets Provider sidecar
+	NoHTTPS                       bool          // Runs an HTTP server when true
+	Port                          int           // Webhook Server port
+	CertFile                      string        // Path to the x509 certificate for https
+	KeyFile                       string        // Path to the x509 private key matching `CertFile`
+	SecretlessContainerImage      string        // Container image for the Secretless sidecar
+	AuthenticatorContainerImage   string        // Container image for the K8s Authenticator sidecar
+	SecretsProviderContainerImage string        // Container image for the Secrets Provider sidecar
+	SecretsProviderRBAC           bool          // Create RBAC for Secrets Provider in k8s_secrets mode
+	SecretlessListeners           bool          // Read and validate Secretless configurations at admission
+	UpgradeWorkloads              bool          // Roll out workloads when the default sidecar images change
+	UpgradeWorkloadsInterval      time.Duration // Minimum time between two rollouts of workloads
+	KeepInjectorAnnotations       bool          // Keep the annotations only used by the sidecar injector on pods
+	ConjurConnectConfigMap        string        // Conjur connect ConfigMap to manage in labeled namespaces, empty to disable
+	GoldenConfigMap               string        // Golden ConfigMap holding the Conjur connection configuration
+	InjectorNamespace             string        // Namespace the injector and golden ConfigMap reside in
+	NamespaceSelectorLabel        string        // Label set to "enabled" on namespaces using the injector
+	NamespaceDefaults             bool          // Use annotations on the pod's namespace as defaults
+	InjectionPolicies             bool          // Inject pods matched by SidecarInjectionPolicies
+	ConfigFile                    string        // Path to the injector configuration file, empty for none
+	AdminAddr                     string        // Address of the admin server serving /preview, empty to disable
+	RedactEnv                     string        // Comma-separated patterns of the environment variables masked in logs and records
+	HashUserInfo                  bool          // Hash the identity of users in logs and records
+	RecordFile                    string        // Path of the admission record file, empty to disable
+	RecordMaxSize                 int           // Size in megabytes above which the admission record file is rotated
+	RecordMaxBackups              int           // Number of rotated admission record files kept
+	RecordRedact                  string        // Comma-separated redactions of the admission records
+	AuditLog                      string        // Path of the audit log, "-" for stdout, empty to disable
+	AuditMaxSize                  int           // Size in megabytes above which the audit log is rotated
+	AuditMaxBackups               int           // Number of rotated audit logs kept
+	InjectionEvents               bool          // Emit events for the injection outcomes of pods on their controllers
 }
 
 func failWithResponse(errMsg string) admissionv1.AdmissionResponse {
@@ -66,9 +108,27 @@ func failWithResponse(errMsg string) admissionv1.AdmissionResponse {
 
 // SidecarInjectorConfig are configuration values for the sidecar injector logic
 type SidecarInjectorConfig struct {
-	SecretlessContainerImage      string // Container image for the Secretless sidecar
-	AuthenticatorContainerImage   string // Container image for the K8s Authenticator sidecar
-	SecretsProviderContainerImage string // Container image for the Secrets Provider
+	SecretlessContainerImage      string               // Container image for the Secretless sidecar
+	AuthenticatorContainerImage   string               // Container image for the K8s Authenticator sidecar
+	SecretsProviderContainerImage string               // Container image for the Secrets Provider
+	SecretsProviderRBAC           bool                 // Create RBAC for Secrets Provider in k8s_secrets mode
+	SecretlessListeners           bool                 // Read and validate Secretless configurations at admission
+	UpgradeWorkloads              bool                 // Stamp workload pod templates with the hash of the default sidecar images
+	KeepInjectorAnnotations       bool                 // Keep the annotations only used by the sidecar injector on pods
+	KubeClient                    kubernetes.Interface // Client for the Kubernetes API
+
+	// Cached namespaces whose annotations provide defaults, nil to disable
+	NamespaceLister corelisters.NamespaceLister
+	// Cached SidecarInjectionPolicies matched against pods, nil to disable
+	PolicyLister SidecarInjectionPolicyLister
+	// Updates the status of the matched SidecarInjectionPolicies in the background, nil to disable
+	PolicyStatus *PolicyStatusUpdater
+	// Client for custom resources, e.g. Secretless Configurations
+	DynamicClient dynamic.Interface
+	// Settings loaded from the injector configuration file
+	ConfigFile ConfigFile
+	// Redaction of the logs, masking every environment variable value when nil
+	Redactor *Redactor
 }
 
 // HandleAdmissionRequest applies the sidecar-injector logic to the AdmissionRequest
@@ -80,6 +140,9 @@ func HandleAdmissionRequest(
 	if req == nil {
 		return failWithResponse("Received empty request")
 	}
+	if response, handled := handleNonCreateRequest(sidecarInjectorConfig, req); handled {
+		return response
+	}
 
 	var pod corev1.Pod
 	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
@@ -96,9 +159,57 @@ func HandleAdmissionRequest(
 		metaName(&pod.ObjectMeta),
 		req.UID,
 		req.Operation,
-		req.UserInfo,
+		sidecarInjectorConfig.redactor().userInfo(req.UserInfo),
 	)
 
+	// The annotations of the pod template, before the defaults are merged in
+	templateAnnotations := make(map[string]string, len(pod.Annotations))
+	for key, value := range pod.Annotations {
+		templateAnnotations[key] = value
+	}
+
+	// Settings are resolved from the pod annotations first, then from the
+	// injection profile, a matching SidecarInjectionPolicy and finally the
+	// defaults declared on the namespace
+	policy, err := matchingPolicy(sidecarInjectorConfig.PolicyLister, req.Namespace, &pod)
+	if err != nil {
+		log.Printf("Unable to list SidecarInjectionPolicies in namespace %s: %v", req.Namespace, err)
+	}
+	var policyAnnotations map[string]string
+	if policy != nil {
+		policyAnnotations = policy.Spec.toAnnotations()
+	}
+	namespaceDefaults := getNamespaceDefaults(sidecarInjectorConfig.NamespaceLister, req.Namespace)
+
+	var profile *InjectionProfile
+	var profileErr error
+	if profileName := firstValue(
+		annotationInjectionProfileKey,
+		pod.Annotations,
+		policyAnnotations,
+		namespaceDefaults,
+	); profileName != "" {
+		profile, profileErr = lookupProfile(sidecarInjectorConfig.ConfigFile.Profiles, profileName)
+		profileAnnotations := map[string]string{annotationInjectKey: "true"}
+		if profile != nil {
+			profileAnnotations = profile.toAnnotations()
+		}
+		merged := mergeAnnotationDefaults(&pod.ObjectMeta, profileAnnotations)
+		log.Printf("Using injection profile %s for %v", profileName, merged)
+	}
+	if policy != nil {
+		merged := mergeAnnotationDefaults(&pod.ObjectMeta, policyAnnotations)
+		log.Printf(
+			"Using SidecarInjectionPolicy %s/%s for %v",
+			req.Namespace,
+			policy.Name,
+			merged,
+		)
+	}
+	if merged := mergeAnnotationDefaults(&pod.ObjectMeta, namespaceDefaults); len(merged) > 0 {
+		log.Printf("Using defaults from namespace %s for %v", req.Namespace, merged)
+	}
+
 	// Determine whether to perform mutation
 	if !mutationRequired(ignoredNamespaces, &pod.ObjectMeta) {
 		log.Printf(
@@ -107,33 +218,69 @@ func HandleAdmissionRequest(
 			metaName(&pod.ObjectMeta),
 		)
 
-		return admissionv1.AdmissionResponse{
+		response := admissionv1.AdmissionResponse{
 			Allowed: true,
 		}
+		if reason := skipReason(templateAnnotations, &pod.ObjectMeta); reason != "" {
+			response.AuditAnnotations = map[string]string{auditAnnotationSkipReason: reason}
+		}
+		return response
 	}
 
-	injectType, _ := getAnnotation(&pod.ObjectMeta, annotationInjectTypeKey)
-	containerMode, _ := getAnnotation(&pod.ObjectMeta, annotationContainerModeKey)
-	containerName, _ := getAnnotation(&pod.ObjectMeta, annotationContainerNameKey)
-	conjurInjectVolumeStr, _ := getAnnotation(
-		&pod.ObjectMeta,
-		annotationConjurInjectVolumesKey,
-	)
-	conjurInjectVolume := strings.Split(conjurInjectVolumeStr, ",")
-	for i := range conjurInjectVolume {
-		conjurInjectVolume[i] = strings.TrimSpace(conjurInjectVolume[i])
+	if profileErr != nil {
+		return failWithResponse(
+			fmt.Sprintf(
+				"Mutation failed for pod %s, in namespace %s, due to %s",
+				pod.Name,
+				req.Namespace,
+				profileErr.Error(),
+			),
+		)
+	}
+
+	injectTypeStr, _ := getAnnotation(&pod.ObjectMeta, annotationInjectTypeKey)
+	injectTypes, err := parseInjectTypes(injectTypeStr)
+	if err != nil {
+		return failWithResponse(
+			fmt.Sprintf(
+				"Mutation failed for pod %s, in namespace %s, due to %s",
+				pod.Name,
+				req.Namespace,
+				err.Error(),
+			),
+		)
 	}
-	var sidecarConfig *PatchConfig
 	annotations := make(map[string]string)
 	annotations[annotationStatusKey] = "injected"
 
-	switch injectType {
-	case "secretless":
+	// Each inject type sees the pod annotations with its own overrides
+	// applied, e.g. `conjur.org/secrets-provider.container-image`
+	var sidecarConfigs []*PatchConfig
+	var warnings []string
+	for _, injectType := range injectTypes {
+		injector, ok := lookupInjector(sidecarInjectorConfig.ConfigFile, injectType)
+		if !ok {
+			errMsg := fmt.Sprintf(
+				"Mutation failed for pod %s, in namespace %s, due to invalid inject type annotation value = %s",
+				pod.Name,
+				req.Namespace,
+				injectType,
+			)
+			log.Print(errMsg)
 
-		secretlessConfig, err := getAnnotation(
-			&pod.ObjectMeta,
-			annotationSecretlessConfigKey,
-		)
+			return admissionv1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: errMsg,
+				},
+			}
+		}
+
+		typePod := pod.DeepCopy()
+		typePod.Annotations = annotationsForType(pod.Annotations, injectType)
+		containerMode, _ := getAnnotation(&typePod.ObjectMeta, annotationContainerModeKey)
+		containerName, _ := getAnnotation(&typePod.ObjectMeta, annotationContainerNameKey)
+		receivers, receiverWarnings, err := getReceivers(typePod)
+		warnings = appendWarnings(warnings, receiverWarnings...)
 		if err != nil {
 			return failWithResponse(
 				fmt.Sprintf(
@@ -145,27 +292,7 @@ func HandleAdmissionRequest(
 			)
 		}
 
-		secretlessCRDSuffix, _ := getAnnotation(&pod.ObjectMeta,
-			annotationSecretlessCRDSuffixKey)
-
-		conjurConnConfigMapName, _ := getAnnotation(
-			&pod.ObjectMeta,
-			annotationConjurConnConfigKey,
-		)
-		conjurAuthConfigMapName, _ := getAnnotation(
-			&pod.ObjectMeta,
-			annotationConjurAuthConfigKey,
-		)
-
-		imageName, err := getAnnotation(
-			&pod.ObjectMeta,
-			annotationContainerImageKey,
-		)
-		if err != nil {
-			imageName = sidecarInjectorConfig.SecretlessContainerImage
-		}
-
-		ServiceAccountTokenVolumeName, err := getServiceAccountTokenVolumeName(&pod)
+		mountSpecs, err := getMountSpecs(typePod, receivers, containerMode)
 		if err != nil {
 			return failWithResponse(
 				fmt.Sprintf(
@@ -177,20 +304,9 @@ func HandleAdmissionRequest(
 			)
 		}
 
-		sidecarConfig = generateSecretlessSidecarConfig(
-			SecretlessSidecarConfig{
-				secretlessConfig:              secretlessConfig,
-				secretlessCRDSuffix:           secretlessCRDSuffix,
-				conjurConnConfigMapName:       conjurConnConfigMapName,
-				conjurAuthConfigMapName:       conjurAuthConfigMapName,
-				serviceAccountTokenVolumeName: ServiceAccountTokenVolumeName,
-				sidecarImage:                  imageName,
-			},
-		)
-	case "authenticator":
-		conjurAuthConfigMapName, err := getAnnotation(
-			&pod.ObjectMeta,
-			annotationConjurAuthConfigKey,
+		envVars, err := getAnnotationEnvVars(
+			typePod.Annotations,
+			sidecarInjectorConfig.ConfigFile.Env.AllowedOverrides,
 		)
 		if err != nil {
 			return failWithResponse(
@@ -203,9 +319,13 @@ func HandleAdmissionRequest(
 			)
 		}
 
-		conjurConnConfigMapName, err := getAnnotation(
-			&pod.ObjectMeta,
-			annotationConjurConnConfigKey,
+		probes, err := getProbeOverrides(
+			typePod,
+			probesForType(
+				sidecarInjectorConfig.ConfigFile.Probes,
+				injectType,
+				!annotationDisabled(&typePod.ObjectMeta, annotationDefaultProbesKey),
+			),
 		)
 		if err != nil {
 			return failWithResponse(
@@ -218,133 +338,125 @@ func HandleAdmissionRequest(
 			)
 		}
 
-		switch containerMode {
-		case "sidecar", "init", "":
-			break
-		default:
+		sidecarConfig, err := injector.Inject(InjectionRequest{
+			Pod:           typePod,
+			Namespace:     req.Namespace,
+			ContainerMode: containerMode,
+			ContainerName: containerName,
+			InjectVolumes: receivers,
+			MountSpecs:    mountSpecs,
+			Config:        sidecarInjectorConfig,
+		})
+		if err == nil {
+			err = validateInitReceivers(typePod, receivers, sidecarConfig)
+		}
+		if err != nil {
 			return failWithResponse(
 				fmt.Sprintf(
-					"Mutation failed for pod %s, in namespace %s, due to %s value (%s) not supported",
+					"Mutation failed for pod %s, in namespace %s, due to %s",
 					pod.Name,
 					req.Namespace,
-					annotationContainerModeKey,
-					containerMode,
+					err.Error(),
 				),
 			)
 		}
+		applyTrustedCABundle(sidecarInjectorConfig.ConfigFile.TrustedCABundle, sidecarConfig)
+		applyEnvVars(sidecarConfig, sidecarInjectorConfig.ConfigFile.Proxy.envVars(conjurApplianceURL()))
+		applyEnvVars(sidecarConfig, sidecarInjectorConfig.ConfigFile.Env.Defaults)
+		applyEnvVars(sidecarConfig, envVars)
+		applyProbes(sidecarConfig, probes)
+		sidecarConfigs = append(sidecarConfigs, sidecarConfig)
+	}
 
-		imageName, err := getAnnotation(
-			&pod.ObjectMeta,
-			annotationContainerImageKey,
+	sidecarConfig, err := mergePatchConfigs(&pod, sidecarConfigs)
+	if err == nil {
+		err = validateVolumeMounts(&pod, sidecarConfig.ContainerVolumeMounts)
+	}
+	if err != nil {
+		return failWithResponse(
+			fmt.Sprintf(
+				"Mutation failed for pod %s, in namespace %s, due to %s",
+				pod.Name,
+				req.Namespace,
+				err.Error(),
+			),
 		)
-		if err != nil {
-			imageName = sidecarInjectorConfig.AuthenticatorContainerImage
-		}
+	}
+	warnings = appendWarnings(warnings, sidecarConfig.Warnings...)
 
-		sidecarConfig = generateAuthenticatorSidecarConfig(AuthenticatorSidecarConfig{
-			conjurConnConfigMapName: conjurConnConfigMapName,
-			conjurAuthConfigMapName: conjurAuthConfigMapName,
-			containerMode:           containerMode,
-			containerName:           containerName,
-			sidecarImage:            imageName,
-		})
+	if profile != nil {
+		applyProfileToPatchConfig(profile, sidecarConfig)
+	}
 
-		containerVolumeMounts := ContainerVolumeMounts{}
-		for _, receiveContainerName := range conjurInjectVolume {
-			containerVolumeMounts[receiveContainerName] = []corev1.VolumeMount{
-				{
-					Name:      "conjur-access-token",
-					ReadOnly:  true,
-					MountPath: "/run/conjur",
-				},
-			}
-		}
-		sidecarConfig.ContainerVolumeMounts = containerVolumeMounts
-	case "secrets-provider":
-		containerImage, err := getAnnotation(
-			&pod.ObjectMeta,
-			annotationContainerImageKey,
+	provenance, err := provenanceAnnotations(injectTypes, sidecarConfigs, sidecarConfig)
+	if err != nil {
+		return failWithResponse(
+			fmt.Sprintf(
+				"Mutation failed for pod %s, in namespace %s, due to %s",
+				pod.Name,
+				req.Namespace,
+				err.Error(),
+			),
 		)
-		if err != nil {
-			containerImage = sidecarInjectorConfig.SecretsProviderContainerImage
-			log.Printf("Using container image %s", containerImage)
-		}
-		switch containerMode {
-		case "sidecar", "init", "":
-			break
-		default:
+	}
+	for key, value := range provenance {
+		annotations[key] = value
+	}
+
+	patchBytes, err := createPatch(
+		req.Object.Raw,
+		sidecarConfig,
+		injectedAnnotations(
+			pod.Annotations,
+			annotations,
+			injectTypes,
+			sidecarInjectorConfig.KeepInjectorAnnotations,
+		),
+	)
+	if err != nil {
+		return failWithResponse(
+			fmt.Sprintf(
+				"Mutation failed for pod %s, in namespace %s, due to %s",
+				pod.Name,
+				req.Namespace,
+				err.Error(),
+			),
+		)
+	}
+
+	// Write to other objects only once the patch is valid
+	for _, rbac := range sidecarConfig.SecretsProviderRBAC {
+		rbac.sideEffects = newSideEffects(req)
+		if err := ensureSecretsProviderRBAC(context.Background(), sidecarInjectorConfig.KubeClient, rbac); err != nil {
 			return failWithResponse(
 				fmt.Sprintf(
-					"Mutation failed for pod %s, in namespace %s, due to %s value (%s) not supported",
+					"Mutation failed for pod %s, in namespace %s, due to %s",
 					pod.Name,
 					req.Namespace,
-					annotationContainerModeKey,
-					containerMode,
+					err.Error(),
 				),
 			)
 		}
-		secretsDestination, err := getAnnotation(
-			&pod.ObjectMeta,
-			annotationSecretsDestinationKey,
-		)
-		if err != nil {
-			secretsDestination = "file"
-			log.Printf("Using secrets destination %s", secretsDestination)
-		}
-		sidecarConfig = generateSecretsProviderSidecarConfig(
-			SecretsProviderSidecarConfig{
-				containerMode:      containerMode,
-				containerName:      containerName,
-				sidecarImage:       containerImage,
-				secretsDestination: secretsDestination,
-			},
-		)
-		containerVolumeMounts := ContainerVolumeMounts{}
-
-		for _, receiveContainerName := range conjurInjectVolume {
-			containerVolumeMounts[receiveContainerName] = []corev1.VolumeMount{
-				{
-					Name:      "conjur-status",
-					ReadOnly:  false,
-					MountPath: "/conjur/status",
-				},
-				{
-					Name:      "conjur-secrets",
-					ReadOnly:  false,
-					MountPath: "/conjur/secrets",
-				},
-			}
-		}
-		sidecarConfig.ContainerVolumeMounts = containerVolumeMounts
-	default:
-		errMsg := fmt.Sprintf(
-			"Mutation failed for pod %s, in namespace %s, due to invalid inject type annotation value = %s",
-			pod.Name,
-			req.Namespace,
-			injectType,
-		)
-		log.Print(errMsg)
+	}
 
-		return admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: errMsg,
-			},
-		}
+	if policy != nil {
+		recordPolicyMatch(
+			sidecarInjectorConfig.PolicyStatus,
+			policy,
+			metaName(&pod.ObjectMeta),
+			newSideEffects(req),
+		)
 	}
 
-	patchBytes, err := createPatch(&pod, sidecarConfig, annotations)
-	if err != nil {
-		return admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
+	for _, warning := range warnings {
+		log.Printf("Warning for pod %s/%s: %s", req.Namespace, metaName(&pod.ObjectMeta), warning)
 	}
 
-	log.Printf("AdmissionResponse: patch=%v\n", printPrettyPatch(patchBytes))
+	log.Printf("AdmissionResponse: patch=%v\n", sidecarInjectorConfig.redactor().patch(patchBytes))
 	return admissionv1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
+		Allowed:  true,
+		Warnings: warnings,
+		Patch:    patchBytes,
 		PatchType: func() *admissionv1.PatchType {
 			pt := admissionv1.PatchTypeJSONPatch
 			return &pt
@@ -394,11 +506,7 @@ func (whsvr *WebhookServer) Serve(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Set AdmissionResponse with results from HandleAdmissionRequest
 		admissionResponse = HandleAdmissionRequest(
-			SidecarInjectorConfig{
-				SecretlessContainerImage:      whsvr.Params.SecretlessContainerImage,
-				AuthenticatorContainerImage:   whsvr.Params.AuthenticatorContainerImage,
-				SecretsProviderContainerImage: whsvr.Params.SecretsProviderContainerImage,
-			},
+			whsvr.InjectorConfig(),
 			admissionRequest,
 		)
 	}
@@ -407,6 +515,20 @@ func (whsvr *WebhookServer) Serve(w http.ResponseWriter, r *http.Request) {
 	// was populated)
 	admissionResponse.UID = admissionRequest.UID
 
+	if whsvr.Recorder != nil && admissionRequest != nil {
+		if err := whsvr.Recorder.Record(admissionRequest, &admissionResponse); err != nil {
+			log.Printf("could not record admission: %v", err)
+		}
+	}
+	if whsvr.AuditLogger != nil && admissionRequest != nil {
+		if err := whsvr.AuditLogger.Log(admissionRequest, &admissionResponse); err != nil {
+			log.Printf("could not write audit record: %v", err)
+		}
+	}
+	if whsvr.EventRecorder != nil && admissionRequest != nil {
+		whsvr.EventRecorder.Emit(admissionRequest, &admissionResponse)
+	}
+
 	// Wrap AdmissonResponse in AdmissionReview, then marshal it to JSON
 	resp, err := json.Marshal(admissionv1.AdmissionReview{
 		TypeMeta: metav1.TypeMeta{
@@ -435,3 +557,23 @@ func NewAdmissionRequest(reviewRequestBytes []byte) (*admissionv1.AdmissionReque
 	log.Printf("Received AdmissionReview, APIVersion: %s, Kind: %s\n", ar.APIVersion, ar.Kind)
 	return ar.Request, err
 }
+
+// InjectorConfig returns the configuration of the sidecar injector logic
+func (whsvr *WebhookServer) InjectorConfig() SidecarInjectorConfig {
+	return SidecarInjectorConfig{
+		SecretlessContainerImage:      whsvr.Params.SecretlessContainerImage,
+		AuthenticatorContainerImage:   whsvr.Params.AuthenticatorContainerImage,
+		SecretsProviderContainerImage: whsvr.Params.SecretsProviderContainerImage,
+		SecretsProviderRBAC:           whsvr.Params.SecretsProviderRBAC,
+		SecretlessListeners:           whsvr.Params.SecretlessListeners,
+		UpgradeWorkloads:              whsvr.Params.UpgradeWorkloads,
+		KeepInjectorAnnotations:       whsvr.Params.KeepInjectorAnnotations,
+		KubeClient:                    whsvr.KubeClient,
+		NamespaceLister:               whsvr.NamespaceLister,
+		PolicyLister:                  whsvr.PolicyLister,
+		PolicyStatus:                  whsvr.PolicyStatus,
+		DynamicClient:                 whsvr.DynamicClient,
+		ConfigFile:                    whsvr.ConfigFile,
+		Redactor:                      whsvr.Redactor,
+	}
+}
@@ -0,0 +1,34 @@
+package inject
+
+import (
+	"log"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// sideEffects performs the writes of an admission to objects other than the
+// admitted one, e.g. the RBAC of Secrets Provider, the status of
+// SidecarInjectionPolicies and events. The webhook is registered with
+// `sideEffects: NoneOnDryRun`, so these writes are skipped for dry-run
+// requests, whose response, patch included, is otherwise identical. The
+// records of the injector itself, e.g. the audit log, are written and marked
+// as dry runs instead.
+type sideEffects struct {
+	dryRun bool
+}
+
+// newSideEffects returns the side effects of an admission request
+func newSideEffects(req *admissionv1.AdmissionRequest) sideEffects {
+	return sideEffects{dryRun: req.DryRun != nil && *req.DryRun}
+}
+
+// write performs a write to another object, described for the logs, e.g.
+// "creation of Role apps/app-conjur-secrets-provider", unless the request is a
+// dry run
+func (effects sideEffects) write(description string, write func() error) error {
+	if effects.dryRun {
+		log.Printf("Dry run: skipping %s", description)
+		return nil
+	}
+	return write()
+}
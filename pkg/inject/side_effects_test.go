@@ -0,0 +1,98 @@
+package inject
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestDryRunSideEffects checks that a dry-run request has the response of a
+// regular request without writing to other objects
+func TestDryRunSideEffects(t *testing.T) {
+	reqJSON, err := newTestAdmissionRequest("./testdata/secrets-provider-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	reqJSON, err = addTestAnnotations(reqJSON, map[string]string{
+		"conjur.org/secrets-destination": "k8s_secrets",
+		"conjur.org/k8s-secrets":         "- db-credentials",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	responses := map[bool]admissionv1.AdmissionResponse{}
+	for _, dryRun := range []bool{true, false} {
+		req, err := NewAdmissionRequest(reqJSON)
+		if !assert.NoError(t, err) {
+			return
+		}
+		req.DryRun = &dryRun
+		client := fake.NewClientset()
+		sidecarInjectorConfig := newTestSidecarInjectorConfig()
+		sidecarInjectorConfig.SecretsProviderRBAC = true
+		sidecarInjectorConfig.KubeClient = client
+
+		logs := captureTestLogs(func() {
+			responses[dryRun] = HandleAdmissionRequest(sidecarInjectorConfig, req)
+		})
+		if !assert.Nil(t, responses[dryRun].Result) {
+			return
+		}
+
+		roleBindings, err := client.RbacV1().RoleBindings("dummy").List(context.Background(), metav1.ListOptions{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		if dryRun {
+			assert.Empty(t, roleBindings.Items)
+			assert.Contains(t, logs, "Dry run: skipping creation of RoleBinding dummy/default-conjur-secrets-provider")
+		} else {
+			assert.Len(t, roleBindings.Items, 1)
+			assert.NotContains(t, logs, "Dry run")
+		}
+	}
+
+	assert.NotEmpty(t, responses[true].Patch)
+	assert.Equal(t, string(responses[false].Patch), string(responses[true].Patch))
+	assert.Equal(t, responses[false].Warnings, responses[true].Warnings)
+}
+
+// TestDeniedAdmissionSideEffects checks that a pod whose patch is invalid is
+// denied without writing to other objects
+func TestDeniedAdmissionSideEffects(t *testing.T) {
+	reqJSON, err := newTestAdmissionRequest("./testdata/secrets-provider-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	reqJSON, err = addTestAnnotations(reqJSON, map[string]string{
+		"conjur.org/secrets-destination": "k8s_secrets",
+		"conjur.org/k8s-secrets":         "- db-credentials",
+		// The Secrets Provider container clashes with the application container
+		"conjur.org/container-name": "nginx-1",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err := NewAdmissionRequest(reqJSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	client := fake.NewClientset()
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.SecretsProviderRBAC = true
+	sidecarInjectorConfig.KubeClient = client
+
+	res := HandleAdmissionRequest(sidecarInjectorConfig, req)
+	if !assert.NotNil(t, res.Result) {
+		return
+	}
+	assert.Contains(t, res.Result.Message, "nginx-1")
+	for _, action := range client.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+}
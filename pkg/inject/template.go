@@ -0,0 +1,105 @@
+package inject
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+// SidecarTemplate is an operator-defined inject type. Its template is Go
+// text/template rendering the YAML of a PatchConfig, i.e. `initContainers`,
+// `containers`, `volumes` and `volumeMounts` keyed by pod container name.
+type SidecarTemplate struct {
+	// Image used when the pod doesn't set `conjur.org/container-image`
+	Image    string `json:"image,omitempty"`
+	Template string `json:"template"`
+
+	// Template parsed once by LoadConfigFile, nil when not parsed yet
+	parsed *template.Template
+}
+
+// sidecarTemplateData is the data available to a SidecarTemplate
+type sidecarTemplateData struct {
+	Pod            interface{}
+	Namespace      string
+	Annotations    map[string]string
+	ContainerMode  string
+	ContainerName  string
+	ContainerImage string
+	InjectVolumes  []string
+}
+
+var sidecarTemplateFuncs = template.FuncMap{
+	"default": func(defaultValue, value string) string {
+		if value == "" {
+			return defaultValue
+		}
+		return value
+	},
+	"quote": strconv.Quote,
+}
+
+func (t SidecarTemplate) parse(name string) (*template.Template, error) {
+	return template.New(name).
+		Option("missingkey=zero").
+		Funcs(sidecarTemplateFuncs).
+		Parse(t.Template)
+}
+
+func (t SidecarTemplate) validate(name string) error {
+	if _, ok := builtinInjectors[name]; ok {
+		return fmt.Errorf("template name %s is reserved for a built-in inject type", name)
+	}
+	if t.Template == "" {
+		return fmt.Errorf("template must not be empty")
+	}
+	if _, err := t.parse(name); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// templateInjector renders a SidecarTemplate into a PatchConfig
+type templateInjector struct {
+	name     string
+	template SidecarTemplate
+}
+
+func (injector templateInjector) Inject(req InjectionRequest) (*PatchConfig, error) {
+	if err := validateContainerMode(req.ContainerMode); err != nil {
+		return nil, err
+	}
+
+	tmpl := injector.template.parsed
+	if tmpl == nil {
+		var err error
+		if tmpl, err = injector.template.parse(injector.name); err != nil {
+			return nil, err
+		}
+	}
+
+	var rendered bytes.Buffer
+	err := tmpl.Execute(&rendered, sidecarTemplateData{
+		Pod:            req.Pod,
+		Namespace:      req.Namespace,
+		Annotations:    req.Pod.Annotations,
+		ContainerMode:  req.ContainerMode,
+		ContainerName:  req.ContainerName,
+		ContainerImage: req.containerImage(injector.template.Image),
+		InjectVolumes:  req.InjectVolumes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to render template %s: %v", injector.name, err)
+	}
+
+	sidecarConfig := &PatchConfig{}
+	if err := yaml.UnmarshalStrict(rendered.Bytes(), sidecarConfig); err != nil {
+		return nil, fmt.Errorf("invalid output of template %s: %v", injector.name, err)
+	}
+
+	return sidecarConfig, nil
+}
@@ -0,0 +1,170 @@
+package inject
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+)
+
+const testLogShipperTemplate = `
+containers:
+- name: {{ default "log-shipper" .ContainerName }}
+  image: {{ .ContainerImage }}
+  env:
+  - name: NAMESPACE
+    value: {{ quote .Namespace }}
+  volumeMounts:
+  - name: conjur-audit
+    mountPath: /var/log/conjur
+    readOnly: true
+volumes:
+- name: conjur-audit
+  emptyDir: {}
+volumeMounts:
+{{- range .InjectVolumes }}
+  {{ . }}:
+  - name: conjur-audit
+    mountPath: /var/log/conjur
+{{- end }}
+`
+
+func TestTemplateInjection(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.ConfigFile = ConfigFile{
+		Templates: map[string]SidecarTemplate{
+			"audit-log-shipper": {
+				Image:    "log-shipper-image",
+				Template: testLogShipperTemplate,
+			},
+		},
+	}
+
+	req, err := newTestAdmissionRequest("./testdata/template-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expectedMod, err := ioutil.ReadFile("./testdata/template-mutated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.JSONEq(t, string(expectedMod), string(mod))
+}
+
+// TestLoadedTemplateInjection checks that the templates of a loaded config
+// file are parsed once, and inject like the ones parsed at admission
+func TestLoadedTemplateInjection(t *testing.T) {
+	content, err := yaml.Marshal(ConfigFile{
+		Templates: map[string]SidecarTemplate{
+			"audit-log-shipper": {
+				Image:    "log-shipper-image",
+				Template: testLogShipperTemplate,
+			},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if !assert.NoError(t, os.WriteFile(path, content, 0600)) {
+		return
+	}
+
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.ConfigFile, err = LoadConfigFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotNil(t, sidecarInjectorConfig.ConfigFile.Templates["audit-log-shipper"].parsed)
+
+	req, err := newTestAdmissionRequest("./testdata/template-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expectedMod, err := ioutil.ReadFile("./testdata/template-mutated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.JSONEq(t, string(expectedMod), string(mod))
+}
+
+func TestTemplateValidation(t *testing.T) {
+	var testCases = []struct {
+		description string
+		name        string
+		template    SidecarTemplate
+		errContains string
+	}{
+		{
+			description: "valid template",
+			name:        "audit-log-shipper",
+			template:    SidecarTemplate{Template: testLogShipperTemplate},
+		},
+		{
+			description: "built-in name",
+			name:        "secretless",
+			template:    SidecarTemplate{Template: testLogShipperTemplate},
+			errContains: "reserved for a built-in inject type",
+		},
+		{
+			description: "empty template",
+			name:        "empty",
+			errContains: "template must not be empty",
+		},
+		{
+			description: "invalid syntax",
+			name:        "broken",
+			template:    SidecarTemplate{Template: "containers: {{ .ContainerName"},
+			errContains: "unclosed action",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := tc.template.validate(tc.name)
+			if tc.errContains == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.errContains)
+			}
+		})
+	}
+}
+
+func TestTemplateInvalidOutput(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	sidecarInjectorConfig.ConfigFile = ConfigFile{
+		Templates: map[string]SidecarTemplate{
+			"audit-log-shipper": {Template: "sidecars: []"},
+		},
+	}
+
+	req, err := newTestAdmissionRequest("./testdata/template-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = applyPatchToAdmissionRequestWithConfig(sidecarInjectorConfig, req)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid output of template audit-log-shipper")
+	}
+}
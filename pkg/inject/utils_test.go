@@ -3,28 +3,49 @@ package inject
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"os"
+	"testing"
 	"text/template"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
 )
 
+// testInjectionTime is recorded in the conjur.org/injected-at annotation of
+// the mutated pod fixtures
+var testInjectionTime = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	now = func() time.Time { return testInjectionTime }
+	os.Exit(m.Run())
+}
+
 // applyPatchToAdmissionRequest runs an AdmissionRequest (wrapped in an AdmissionReview)
 // through the sidecar-injector logic to extract a mutation patch, it then applies this
 // patch to the origin Pod template spec to return the mutated Pod template spec.
 func applyPatchToAdmissionRequest(reviewRequestBytes []byte) ([]byte, error) {
+	return applyPatchToAdmissionRequestWithConfig(
+		newTestSidecarInjectorConfig(),
+		reviewRequestBytes,
+	)
+}
+
+// applyPatchToAdmissionRequestWithConfig is applyPatchToAdmissionRequest for a
+// given SidecarInjectorConfig.
+func applyPatchToAdmissionRequestWithConfig(
+	sidecarInjectorConfig SidecarInjectorConfig,
+	reviewRequestBytes []byte,
+) ([]byte, error) {
 	req, err := NewAdmissionRequest(reviewRequestBytes)
 	if err != nil {
 		return nil, err
 	}
-	admissionRes := HandleAdmissionRequest(
-		SidecarInjectorConfig{
-			SecretlessContainerImage:      "secretless-image",
-			AuthenticatorContainerImage:   "authenticator-image",
-			SecretsProviderContainerImage: "secrets-provider-image",
-		},
-		req,
-	)
+	admissionRes := HandleAdmissionRequest(sidecarInjectorConfig, req)
+	if admissionRes.Result != nil {
+		return nil, errors.New(admissionRes.Result.Message)
+	}
 
 	patch, err := jsonpatch.DecodePatch(admissionRes.Patch)
 	if err != nil {
@@ -34,6 +55,16 @@ func applyPatchToAdmissionRequest(reviewRequestBytes []byte) ([]byte, error) {
 	return patch.Apply(req.Object.Raw)
 }
 
+// newTestSidecarInjectorConfig returns the SidecarInjectorConfig used by the
+// fixture based tests.
+func newTestSidecarInjectorConfig() SidecarInjectorConfig {
+	return SidecarInjectorConfig{
+		SecretlessContainerImage:      "secretless-image",
+		AuthenticatorContainerImage:   "authenticator-image",
+		SecretsProviderContainerImage: "secrets-provider-image",
+	}
+}
+
 // newTestAdmissionRequest creates an Admission Request (wrapped in a Admission Review).
 // This is done by embedding a pod template spec, whose path is an argument, inside the
 // shell of an example Admission Request. This method simplifies generating test
@@ -65,3 +96,30 @@ func newTestAdmissionRequest(podTemplateSpecPath string) ([]byte, error) {
 
 	return reqPrettyJSON.Bytes(), nil
 }
+
+// addTestAnnotations sets annotations on the pod embedded in an Admission Request
+// (wrapped in an Admission Review), so that fixtures can be reused with extra
+// annotations.
+func addTestAnnotations(reviewRequestBytes []byte, annotations map[string]string) ([]byte, error) {
+	var review map[string]interface{}
+	if err := json.Unmarshal(reviewRequestBytes, &review); err != nil {
+		return nil, err
+	}
+
+	request, _ := review["request"].(map[string]interface{})
+	object, _ := request["object"].(map[string]interface{})
+	metadata, _ := object["metadata"].(map[string]interface{})
+	if metadata == nil {
+		return nil, errors.New("admission request has no pod metadata")
+	}
+	podAnnotations, _ := metadata["annotations"].(map[string]interface{})
+	if podAnnotations == nil {
+		podAnnotations = map[string]interface{}{}
+		metadata["annotations"] = podAnnotations
+	}
+	for key, value := range annotations {
+		podAnnotations[key] = value
+	}
+
+	return json.Marshal(review)
+}
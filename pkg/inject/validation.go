@@ -0,0 +1,72 @@
+package inject
+
+import (
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validatePod checks the fields of a mutated pod that the injection changes
+// against the rules of the Kubernetes API server, so that an invalid mutation
+// fails with a clear error: container and volume names, volume mounts and
+// annotations
+func validatePod(pod *corev1.Pod) error {
+	var allErrs field.ErrorList
+
+	metadataPath := field.NewPath("metadata")
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(pod.Annotations, metadataPath.Child("annotations"))...)
+
+	specPath := field.NewPath("spec")
+	volumes := sets.New[string]()
+	for i, volume := range pod.Spec.Volumes {
+		idxPath := specPath.Child("volumes").Index(i).Child("name")
+		for _, msg := range validation.IsDNS1123Label(volume.Name) {
+			allErrs = append(allErrs, field.Invalid(idxPath, volume.Name, msg))
+		}
+		if volumes.Has(volume.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath, volume.Name))
+		}
+		volumes.Insert(volume.Name)
+	}
+
+	containerNames := sets.New[string]()
+	validateContainer := func(fldPath *field.Path, name string, volumeMounts []corev1.VolumeMount) {
+		for _, msg := range validation.IsDNS1123Label(name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), name, msg))
+		}
+		if containerNames.Has(name) {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("name"), name))
+		}
+		containerNames.Insert(name)
+
+		mountPaths := sets.New[string]()
+		for i, volumeMount := range volumeMounts {
+			idxPath := fldPath.Child("volumeMounts").Index(i)
+			if !volumes.Has(volumeMount.Name) {
+				allErrs = append(allErrs, field.NotFound(idxPath.Child("name"), volumeMount.Name))
+			}
+			mountPath := path.Clean(volumeMount.MountPath)
+			if volumeMount.MountPath == "" {
+				allErrs = append(allErrs, field.Required(idxPath.Child("mountPath"), ""))
+			} else if mountPaths.Has(mountPath) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("mountPath"), volumeMount.MountPath, "must be unique"))
+			}
+			mountPaths.Insert(mountPath)
+		}
+	}
+	for i, container := range pod.Spec.InitContainers {
+		validateContainer(specPath.Child("initContainers").Index(i), container.Name, container.VolumeMounts)
+	}
+	for i, container := range pod.Spec.Containers {
+		validateContainer(specPath.Child("containers").Index(i), container.Name, container.VolumeMounts)
+	}
+	for i, container := range pod.Spec.EphemeralContainers {
+		validateContainer(specPath.Child("ephemeralContainers").Index(i), container.Name, container.VolumeMounts)
+	}
+
+	return allErrs.ToAggregate()
+}
@@ -0,0 +1,66 @@
+package inject
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const defaultWaitForSecretsTimeout = 2 * time.Minute
+
+// waitForSecretsTimeout returns how long the application containers of the
+// pod may wait for the secrets, or zero when the pod doesn't ask to wait
+func waitForSecretsTimeout(pod *corev1.Pod) (time.Duration, error) {
+	if !annotationEnabled(&pod.ObjectMeta, annotationWaitForSecretsKey) {
+		return 0, nil
+	}
+
+	timeoutStr, err := getAnnotation(&pod.ObjectMeta, annotationWaitForSecretsTimeoutKey)
+	if err != nil {
+		return defaultWaitForSecretsTimeout, nil
+	}
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil || timeout < time.Second {
+		return 0, fmt.Errorf(
+			"%s value (%s) must be a duration of at least 1s, e.g. 90s",
+			annotationWaitForSecretsTimeoutKey,
+			timeoutStr,
+		)
+	}
+
+	return timeout, nil
+}
+
+// gateStartupOnFile holds back the start of the application containers
+// until the injected sidecars have written a file. The kubelet starts the
+// containers of a pod in order, and doesn't start the next one until the
+// postStart hook of the previous one has completed, so the sidecars are moved
+// in front of the application containers with a postStart hook waiting for
+// the file. A sidecar whose file doesn't appear in time is killed, and
+// restarted according to the restart policy of the pod.
+func gateStartupOnFile(sidecarConfig *PatchConfig, file string, timeout time.Duration) {
+	seconds := int(timeout.Round(time.Second) / time.Second)
+	for i := range sidecarConfig.Containers {
+		container := &sidecarConfig.Containers[i]
+		if container.Lifecycle == nil {
+			container.Lifecycle = &corev1.Lifecycle{}
+		}
+		container.Lifecycle.PostStart = &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"sh", "-c", waitForFileCommand(file, seconds)},
+			},
+		}
+		sidecarConfig.StartFirst = append(sidecarConfig.StartFirst, container.Name)
+	}
+}
+
+// waitForFileCommand returns a shell command that waits for a file to exist,
+// failing after the given number of seconds
+func waitForFileCommand(file string, seconds int) string {
+	return fmt.Sprintf(
+		"i=0; until [ -f %s ]; do i=$((i+1)); if [ $i -gt %d ]; then exit 1; fi; sleep 1; done",
+		file,
+		seconds,
+	)
+}
@@ -0,0 +1,229 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWaitForSecretsTimeout(t *testing.T) {
+	var testCases = []struct {
+		description string
+		annotations map[string]string
+		expected    time.Duration
+		errContains string
+	}{
+		{
+			description: "not annotated",
+			annotations: map[string]string{},
+		},
+		{
+			description: "disabled",
+			annotations: map[string]string{"conjur.org/wait-for-secrets": "false"},
+		},
+		{
+			description: "default timeout",
+			annotations: map[string]string{"conjur.org/wait-for-secrets": "true"},
+			expected:    2 * time.Minute,
+		},
+		{
+			description: "custom timeout",
+			annotations: map[string]string{
+				"conjur.org/wait-for-secrets":         "yes",
+				"conjur.org/wait-for-secrets-timeout": "45s",
+			},
+			expected: 45 * time.Second,
+		},
+		{
+			description: "invalid timeout",
+			annotations: map[string]string{
+				"conjur.org/wait-for-secrets":         "true",
+				"conjur.org/wait-for-secrets-timeout": "45",
+			},
+			errContains: "conjur.org/wait-for-secrets-timeout value (45) must be a duration",
+		},
+		{
+			description: "timeout too short",
+			annotations: map[string]string{
+				"conjur.org/wait-for-secrets":         "true",
+				"conjur.org/wait-for-secrets-timeout": "100ms",
+			},
+			errContains: "must be a duration of at least 1s",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+
+			timeout, err := waitForSecretsTimeout(pod)
+			if tc.errContains != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.errContains)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, timeout)
+		})
+	}
+}
+
+func TestMoveContainersFirst(t *testing.T) {
+	containers := func(names ...string) []corev1.Container {
+		var result []corev1.Container
+		for _, name := range names {
+			result = append(result, corev1.Container{Name: name})
+		}
+		return result
+	}
+
+	var testCases = []struct {
+		description string
+		containers  []corev1.Container
+		names       []string
+		expected    []corev1.Container
+	}{
+		{
+			description: "nothing to move",
+			containers:  containers("app", "sidecar"),
+			expected:    containers("app", "sidecar"),
+		},
+		{
+			description: "single sidecar",
+			containers:  containers("app", "worker", "sidecar"),
+			names:       []string{"sidecar"},
+			expected:    containers("sidecar", "app", "worker"),
+		},
+		{
+			description: "several sidecars keep their order",
+			containers:  containers("app", "authenticator", "secrets-provider"),
+			names:       []string{"authenticator", "secrets-provider"},
+			expected:    containers("authenticator", "secrets-provider", "app"),
+		},
+		{
+			description: "already first",
+			containers:  containers("sidecar"),
+			names:       []string{"sidecar"},
+			expected:    containers("sidecar"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, moveContainersFirst(tc.containers, tc.names))
+		})
+	}
+}
+
+func TestWaitForSecretsInjection(t *testing.T) {
+	var testCases = []struct {
+		description   string
+		fixture       string
+		sidecarName   string
+		file          string
+		appContainers []string
+		// Volume mounted into the last application container
+		receiverVolume string
+	}{
+		{
+			description:    "authenticator",
+			fixture:        "./testdata/authenticator-annotated-pod.json",
+			sidecarName:    "authenticator-name",
+			file:           "/run/conjur/conjur-access-token",
+			appContainers:  []string{"nginx-1", "nginx-2"},
+			receiverVolume: "conjur-access-token",
+		},
+		{
+			description:    "secrets provider",
+			fixture:        "./testdata/secrets-provider-annotated-pod.json",
+			sidecarName:    "secrets-provider-name",
+			file:           "/conjur/status/CONJUR_SECRETS_PROVIDED",
+			appContainers:  []string{"nginx-1"},
+			receiverVolume: "conjur-secrets",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req, err := newTestAdmissionRequest(tc.fixture)
+			if !assert.NoError(t, err) {
+				return
+			}
+			req, err = addTestAnnotations(req, map[string]string{
+				"conjur.org/wait-for-secrets":         "true",
+				"conjur.org/wait-for-secrets-timeout": "30s",
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			mod, err := applyPatchToAdmissionRequest(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var pod corev1.Pod
+			if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+				return
+			}
+
+			var names []string
+			for _, container := range pod.Spec.Containers {
+				names = append(names, container.Name)
+			}
+			assert.Equal(t, append([]string{tc.sidecarName}, tc.appContainers...), names)
+
+			sidecar := pod.Spec.Containers[0]
+			if assert.NotNil(t, sidecar.Lifecycle) && assert.NotNil(t, sidecar.Lifecycle.PostStart) {
+				assert.Equal(
+					t,
+					[]string{"sh", "-c", waitForFileCommand(tc.file, 30)},
+					sidecar.Lifecycle.PostStart.Exec.Command,
+				)
+			}
+			// Volume mounts are patched by index before the sidecar is moved
+			receiver := pod.Spec.Containers[len(pod.Spec.Containers)-1]
+			var mountNames []string
+			for _, volumeMount := range receiver.VolumeMounts {
+				mountNames = append(mountNames, volumeMount.Name)
+			}
+			assert.Contains(t, mountNames, tc.receiverVolume)
+			assert.NotContains(t, pod.Annotations, "conjur.org/wait-for-secrets")
+			assert.NotContains(t, pod.Annotations, "conjur.org/wait-for-secrets-timeout")
+		})
+	}
+}
+
+func TestWaitForSecretsInitMode(t *testing.T) {
+	req, err := newTestAdmissionRequest("./testdata/secrets-provider-init-annotated-pod.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err = addTestAnnotations(req, map[string]string{
+		"conjur.org/wait-for-secrets": "true",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mod, err := applyPatchToAdmissionRequest(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var pod corev1.Pod
+	if !assert.NoError(t, json.Unmarshal(mod, &pod)) {
+		return
+	}
+
+	// Init containers complete before the application containers start
+	for _, container := range pod.Spec.InitContainers {
+		assert.Nil(t, container.Lifecycle)
+	}
+	for _, container := range pod.Spec.Containers {
+		assert.Nil(t, container.Lifecycle)
+	}
+}
@@ -0,0 +1,304 @@
+package inject
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// workloadUpgraderLease is the Lease held by the injector replica elected to
+// upgrade the workloads
+const workloadUpgraderLease = "cyberark-sidecar-injector-workload-upgrader"
+
+// Workload resources whose pod template is stamped with the hash of the
+// default sidecar images, so that they roll out when these images change
+var workloadResources = map[string]bool{
+	"deployments":  true,
+	"statefulsets": true,
+	"daemonsets":   true,
+}
+
+func isWorkloadResource(resource metav1.GroupVersionResource) bool {
+	return resource.Group == "apps" && workloadResources[resource.Resource]
+}
+
+// podTemplateWorkload is the part shared by the workload resources
+type podTemplateWorkload struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Template corev1.PodTemplateSpec `json:"template"`
+	} `json:"spec"`
+}
+
+// defaultSidecarImage returns the image of an inject type used when a pod
+// doesn't set `conjur.org/container-image`
+func defaultSidecarImage(sidecarInjectorConfig SidecarInjectorConfig, injectType string) string {
+	switch injectType {
+	case "secretless":
+		return sidecarInjectorConfig.SecretlessContainerImage
+	case "authenticator":
+		return sidecarInjectorConfig.AuthenticatorContainerImage
+	case "secrets-provider":
+		return sidecarInjectorConfig.SecretsProviderContainerImage
+	default:
+		return sidecarInjectorConfig.ConfigFile.Templates[injectType].Image
+	}
+}
+
+// sidecarImagesHash returns the hash of the default images of the sidecars
+// injected into the pods of a template, or an empty string when the template
+// isn't annotated for injection or pins all its sidecar images
+func sidecarImagesHash(sidecarInjectorConfig SidecarInjectorConfig, metadata *metav1.ObjectMeta) string {
+	if !annotationEnabled(metadata, annotationInjectKey) {
+		return ""
+	}
+
+	injectTypeStr, _ := getAnnotation(metadata, annotationInjectTypeKey)
+	injectTypes, err := parseInjectTypes(injectTypeStr)
+	if err != nil {
+		return ""
+	}
+
+	var images []string
+	for _, injectType := range injectTypes {
+		typeAnnotations := annotationsForType(metadata.Annotations, injectType)
+		if _, ok := typeAnnotations[annotationContainerImageKey]; ok {
+			continue
+		}
+		if image := defaultSidecarImage(sidecarInjectorConfig, injectType); image != "" {
+			images = append(images, injectType+"="+image)
+		}
+	}
+	if len(images) == 0 {
+		return ""
+	}
+	sort.Strings(images)
+
+	sum := sha256.Sum256([]byte(strings.Join(images, "\n")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// handleWorkloadRequest stamps the pod template of a workload annotated for
+// injection with the hash of the default sidecar images
+func handleWorkloadRequest(
+	sidecarInjectorConfig SidecarInjectorConfig,
+	req *admissionv1.AdmissionRequest,
+) admissionv1.AdmissionResponse {
+	if !sidecarInjectorConfig.UpgradeWorkloads {
+		return admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	var workload podTemplateWorkload
+	if err := json.Unmarshal(req.Object.Raw, &workload); err != nil {
+		return failWithResponse(
+			fmt.Sprintf("Could not unmarshal raw object: %v", err),
+		)
+	}
+
+	template := &workload.Spec.Template
+	hash := sidecarImagesHash(sidecarInjectorConfig, &template.ObjectMeta)
+	current, _ := getAnnotation(&template.ObjectMeta, annotationSidecarImagesHashKey)
+	if hash == "" || hash == current {
+		return admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	// The template has annotations, as it is annotated for injection
+	patchBytes, err := json.Marshal([]rfc6902PatchOperation{
+		{
+			Op:    patchOperationAdd,
+			Path:  "/spec/template/metadata/annotations/" + escapeJSONPointer(annotationSidecarImagesHashKey),
+			Value: hash,
+		},
+	})
+	if err != nil {
+		return failWithResponse(
+			fmt.Sprintf(
+				"Mutation failed for %s %s, in namespace %s, due to %s",
+				req.Kind.Kind,
+				workload.Name,
+				req.Namespace,
+				err.Error(),
+			),
+		)
+	}
+
+	log.Printf(
+		"Setting sidecar images hash %s on the pod template of %s %s/%s",
+		hash,
+		req.Kind.Kind,
+		req.Namespace,
+		workload.Name,
+	)
+	return admissionv1.AdmissionResponse{
+		Allowed: true,
+		Patch:   patchBytes,
+		PatchType: func() *admissionv1.PatchType {
+			pt := admissionv1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
+
+// WorkloadUpgrader rolls out the workloads whose pod templates were stamped
+// with the hash of other default sidecar images than the current ones, so that
+// their pods are injected again with the current images.
+type WorkloadUpgrader struct {
+	Client          kubernetes.Interface
+	Config          SidecarInjectorConfig
+	NamespaceLabel  string        // Label set to "enabled" on namespaces using the injector
+	RolloutInterval time.Duration // Minimum time between two rollouts
+
+	lastRollout time.Time
+}
+
+// RunAsLeader upgrades the stale workloads once, when elected leader among
+// the replicas of the injector by holding a Lease in the given namespace, so
+// that replicas starting together don't roll out the same workloads. The
+// Lease is held until the context is cancelled.
+func (u *WorkloadUpgrader) RunAsLeader(ctx context.Context, namespace, identity string) error {
+	return runAsLeader(ctx, u.Client, namespace, workloadUpgraderLease, identity, u.Run)
+}
+
+// Run upgrades the stale workloads of the labeled namespaces once. Workloads
+// that can't be listed or upgraded are logged and skipped.
+func (u *WorkloadUpgrader) Run(ctx context.Context) error {
+	namespaces, err := u.Client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{u.NamespaceLabel: "enabled"}.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces.Items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		u.upgradeNamespace(ctx, namespace.Name)
+	}
+
+	return nil
+}
+
+func (u *WorkloadUpgrader) upgradeNamespace(ctx context.Context, namespace string) {
+	apps := u.Client.AppsV1()
+
+	deployments, err := apps.Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Failed to list Deployments in namespace %s: %v", namespace, err)
+		deployments = &appsv1.DeploymentList{}
+	}
+	for _, deployment := range deployments.Items {
+		u.upgrade(ctx, "Deployment", namespace, deployment.Name, &deployment.Spec.Template,
+			func(patch []byte) error {
+				_, err := apps.Deployments(namespace).Patch(ctx, deployment.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+		)
+	}
+
+	statefulSets, err := apps.StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Failed to list StatefulSets in namespace %s: %v", namespace, err)
+		statefulSets = &appsv1.StatefulSetList{}
+	}
+	for _, statefulSet := range statefulSets.Items {
+		u.upgrade(ctx, "StatefulSet", namespace, statefulSet.Name, &statefulSet.Spec.Template,
+			func(patch []byte) error {
+				_, err := apps.StatefulSets(namespace).Patch(ctx, statefulSet.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+		)
+	}
+
+	daemonSets, err := apps.DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Failed to list DaemonSets in namespace %s: %v", namespace, err)
+		daemonSets = &appsv1.DaemonSetList{}
+	}
+	for _, daemonSet := range daemonSets.Items {
+		u.upgrade(ctx, "DaemonSet", namespace, daemonSet.Name, &daemonSet.Spec.Template,
+			func(patch []byte) error {
+				_, err := apps.DaemonSets(namespace).Patch(ctx, daemonSet.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+		)
+	}
+}
+
+// upgrade sets the current sidecar images hash on a stamped pod template
+// that has another one, which rolls out the workload, at most once per
+// RolloutInterval. Failures are logged, as the webhook may not serve yet when
+// the injector starts, and retried.
+func (u *WorkloadUpgrader) upgrade(
+	ctx context.Context,
+	kind string,
+	namespace string,
+	name string,
+	template *corev1.PodTemplateSpec,
+	apply func(patch []byte) error,
+) {
+	current, err := getAnnotation(&template.ObjectMeta, annotationSidecarImagesHashKey)
+	if err != nil {
+		return
+	}
+	hash := sidecarImagesHash(u.Config, &template.ObjectMeta)
+	if hash == "" || hash == current {
+		return
+	}
+	if !u.waitForRollout(ctx) {
+		return
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{annotationSidecarImagesHashKey: hash},
+				},
+			},
+		},
+	})
+	if err == nil {
+		err = retry.OnError(retry.DefaultBackoff, func(error) bool { return ctx.Err() == nil }, func() error {
+			return apply(patch)
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to upgrade sidecars of %s %s/%s: %v", kind, namespace, name, err)
+		return
+	}
+
+	log.Printf("Upgrading sidecars of %s %s/%s, from images hash %s to %s", kind, namespace, name, current, hash)
+}
+
+// waitForRollout waits until RolloutInterval elapsed since the last rollout,
+// and tells whether a rollout may start, i.e. the context isn't cancelled
+func (u *WorkloadUpgrader) waitForRollout(ctx context.Context) bool {
+	if !u.lastRollout.IsZero() {
+		timer := time.NewTimer(time.Until(u.lastRollout.Add(u.RolloutInterval)))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	u.lastRollout = time.Now()
+
+	return true
+}
@@ -0,0 +1,268 @@
+package inject
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestDeployment(namespace, name string, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			},
+		},
+	}
+}
+
+func TestSidecarImagesHash(t *testing.T) {
+	sidecarInjectorConfig := newTestSidecarInjectorConfig()
+	hash := func(annotations map[string]string) string {
+		return sidecarImagesHash(sidecarInjectorConfig, &metav1.ObjectMeta{Annotations: annotations})
+	}
+
+	authenticator := hash(map[string]string{
+		"conjur.org/inject":      "yes",
+		"conjur.org/inject-type": "authenticator",
+	})
+	assert.Len(t, authenticator, 16)
+
+	assert.Empty(t, hash(nil))
+	assert.Empty(t, hash(map[string]string{
+		"conjur.org/inject":      "no",
+		"conjur.org/inject-type": "authenticator",
+	}))
+	assert.Empty(t, hash(map[string]string{
+		"conjur.org/inject":          "yes",
+		"conjur.org/inject-type":     "authenticator",
+		"conjur.org/container-image": "my-authenticator",
+	}))
+
+	multiType := hash(map[string]string{
+		"conjur.org/inject":      "yes",
+		"conjur.org/inject-type": "authenticator,secretless",
+	})
+	assert.NotEqual(t, authenticator, multiType)
+	assert.Equal(t, authenticator, hash(map[string]string{
+		"conjur.org/inject":                     "yes",
+		"conjur.org/inject-type":                "authenticator,secretless",
+		"conjur.org/secretless.container-image": "my-secretless",
+	}))
+
+	sidecarInjectorConfig.AuthenticatorContainerImage = "authenticator-image:2"
+	assert.NotEqual(t, authenticator, hash(map[string]string{
+		"conjur.org/inject":      "yes",
+		"conjur.org/inject-type": "authenticator",
+	}))
+}
+
+func TestWorkloadRequest(t *testing.T) {
+	annotations := map[string]string{
+		"conjur.org/inject":      "yes",
+		"conjur.org/inject-type": "authenticator",
+	}
+	currentHash := sidecarImagesHash(
+		newTestSidecarInjectorConfig(),
+		&metav1.ObjectMeta{Annotations: annotations},
+	)
+	withHash := func(hash string) map[string]string {
+		stamped := map[string]string{"conjur.org/sidecar-images-hash": hash}
+		for key, value := range annotations {
+			stamped[key] = value
+		}
+		return stamped
+	}
+
+	var testCases = []struct {
+		description string
+		annotations map[string]string
+		disabled    bool
+		patched     bool
+	}{
+		{
+			description: "template stamped",
+			annotations: annotations,
+			patched:     true,
+		},
+		{
+			description: "stale hash replaced",
+			annotations: withHash("0123456789abcdef"),
+			patched:     true,
+		},
+		{
+			description: "current hash",
+			annotations: withHash(currentHash),
+		},
+		{
+			description: "template not annotated for injection",
+			annotations: map[string]string{"app": "test"},
+		},
+		{
+			description: "upgrades disabled",
+			annotations: annotations,
+			disabled:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			sidecarInjectorConfig := newTestSidecarInjectorConfig()
+			sidecarInjectorConfig.UpgradeWorkloads = !tc.disabled
+
+			deploymentJSON, err := json.Marshal(newTestDeployment("dummy", "test-app", tc.annotations))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			res := HandleAdmissionRequest(sidecarInjectorConfig, &admissionv1.AdmissionRequest{
+				Namespace: "dummy",
+				Name:      "test-app",
+				Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				Resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+				Operation: admissionv1.Update,
+				Object:    runtime.RawExtension{Raw: deploymentJSON},
+			})
+			assert.True(t, res.Allowed)
+			if !tc.patched {
+				assert.Nil(t, res.Patch)
+				return
+			}
+
+			patch, err := jsonpatch.DecodePatch(res.Patch)
+			if !assert.NoError(t, err) {
+				return
+			}
+			mod, err := patch.Apply(deploymentJSON)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var deployment appsv1.Deployment
+			if !assert.NoError(t, json.Unmarshal(mod, &deployment)) {
+				return
+			}
+			assert.Equal(t, withHash(currentHash), deployment.Spec.Template.Annotations)
+		})
+	}
+}
+
+func TestWorkloadUpgrader(t *testing.T) {
+	annotations := map[string]string{
+		"conjur.org/inject":              "yes",
+		"conjur.org/inject-type":         "authenticator",
+		"conjur.org/sidecar-images-hash": "0123456789abcdef",
+	}
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "enabled",
+			Labels: map[string]string{"conjur.org/sidecar-injector": "enabled"},
+		}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "broken",
+			Labels: map[string]string{"conjur.org/sidecar-injector": "enabled"},
+		}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+		newTestDeployment("enabled", "stale", annotations),
+		newTestDeployment("enabled", "stale-2", annotations),
+		newTestDeployment("enabled", "not-stamped", map[string]string{
+			"conjur.org/inject":      "yes",
+			"conjur.org/inject-type": "authenticator",
+		}),
+		newTestDeployment("other", "stale", annotations),
+	)
+	// Namespaces whose workloads can't be listed don't stop the upgrade
+	client.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "broken" {
+			return true, nil, errors.New("forbidden")
+		}
+		return false, nil, nil
+	})
+
+	upgrader := &WorkloadUpgrader{
+		Client:          client,
+		Config:          newTestSidecarInjectorConfig(),
+		NamespaceLabel:  "conjur.org/sidecar-injector",
+		RolloutInterval: 100 * time.Millisecond,
+	}
+	start := time.Now()
+	var err error
+	logs := captureTestLogs(func() {
+		err = upgrader.Run(context.Background())
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Contains(t, logs, "Failed to list Deployments in namespace broken: forbidden")
+	// The two rollouts are spaced by the interval
+	assert.GreaterOrEqual(t, time.Since(start), upgrader.RolloutInterval)
+
+	currentHash := sidecarImagesHash(upgrader.Config, &metav1.ObjectMeta{Annotations: annotations})
+	templateHash := func(namespace, name string) string {
+		deployment, err := client.AppsV1().Deployments(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		return deployment.Spec.Template.Annotations["conjur.org/sidecar-images-hash"]
+	}
+	assert.Equal(t, currentHash, templateHash("enabled", "stale"))
+	assert.Equal(t, currentHash, templateHash("enabled", "stale-2"))
+	assert.Empty(t, templateHash("enabled", "not-stamped"))
+	assert.Equal(t, "0123456789abcdef", templateHash("other", "stale"))
+}
+
+func TestWorkloadUpgraderLeaderElection(t *testing.T) {
+	annotations := map[string]string{
+		"conjur.org/inject":              "yes",
+		"conjur.org/inject-type":         "authenticator",
+		"conjur.org/sidecar-images-hash": "0123456789abcdef",
+	}
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "enabled",
+			Labels: map[string]string{"conjur.org/sidecar-injector": "enabled"},
+		}},
+		newTestDeployment("enabled", "stale", annotations),
+	)
+	upgrader := &WorkloadUpgrader{
+		Client:         client,
+		Config:         newTestSidecarInjectorConfig(),
+		NamespaceLabel: "conjur.org/sidecar-injector",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- upgrader.RunAsLeader(ctx, "injectors", "injector-0")
+	}()
+
+	currentHash := sidecarImagesHash(upgrader.Config, &metav1.ObjectMeta{Annotations: annotations})
+	assert.Eventually(t, func() bool {
+		deployment, err := client.AppsV1().Deployments("enabled").Get(context.Background(), "stale", metav1.GetOptions{})
+		return err == nil && deployment.Spec.Template.Annotations["conjur.org/sidecar-images-hash"] == currentHash
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lease, err := client.CoordinationV1().Leases("injectors").Get(
+		context.Background(),
+		"cyberark-sidecar-injector-workload-upgrader",
+		metav1.GetOptions{},
+	)
+	if assert.NoError(t, err) && assert.NotNil(t, lease.Spec.HolderIdentity) {
+		assert.Equal(t, "injector-0", *lease.Spec.HolderIdentity)
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}